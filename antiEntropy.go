@@ -0,0 +1,451 @@
+package hamt_go
+
+// hamt_go/antiEntropy.go
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// Anti-entropy synchronization between two Merkle HAMTs.  One replica
+// serves its state with ServeSync; the other calls PullSync over the
+// same connection.  The puller walks the binary tree over the Root's
+// slots and then the Tables below, descending only where its digests
+// differ from those of the server.  It then fetches just the leaves
+// which differ, so the traffic is proportional to the difference
+// between the replicas rather than to their size.  When PullSync
+// returns without error, the two replicas have the same root digest.
+//
+// Each request is a one-byte code followed by its arguments; integers
+// are sent as uvarints, byte strings as a uvarint length followed by
+// the bytes themselves, and a schedule of table widths as a count
+// followed by the widths.
+const (
+	syncReqRoot = byte(1) // -> widths, t, top of binary tree over root slots
+	syncReqTree = byte(2) // i -> digests of tree[2i] and tree[2i+1]
+	syncReqNode = byte(3) // path -> description of the node at path
+	syncReqDone = byte(4) // end of session; no reply
+)
+
+// Kinds of node described in reply to syncReqNode.
+const (
+	syncEmpty = byte(0) // nothing there
+	syncLeaf  = byte(1) // followed by the key and value
+	syncTable = byte(2) // followed by count and (index, digest) pairs
+)
+
+// Upper bound on the length of keys and values accepted during a sync.
+const maxSyncBytes = 1 << 24
+
+// A connection carrying the sync protocol.  The first error encountered
+// is sticky: once it is set, later reads and writes do nothing.
+type syncConn struct {
+	r   *bufio.Reader
+	w   *bufio.Writer
+	err error
+}
+
+func newSyncConn(rw io.ReadWriter) *syncConn {
+	return &syncConn{
+		r: bufio.NewReader(rw),
+		w: bufio.NewWriter(rw),
+	}
+}
+
+func (c *syncConn) writeByte(b byte) {
+	if c.err == nil {
+		c.err = c.w.WriteByte(b)
+	}
+}
+
+func (c *syncConn) writeUvarint(n uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	if c.err == nil {
+		_, c.err = c.w.Write(buf[:binary.PutUvarint(buf[:], n)])
+	}
+}
+
+func (c *syncConn) writeRaw(b []byte) {
+	if c.err == nil {
+		_, c.err = c.w.Write(b)
+	}
+}
+
+// Write a byte string preceded by its length.
+func (c *syncConn) writeBytes(b []byte) {
+	c.writeUvarint(uint64(len(b)))
+	c.writeRaw(b)
+}
+
+func (c *syncConn) flush() {
+	if c.err == nil {
+		c.err = c.w.Flush()
+	}
+}
+
+func (c *syncConn) readByte() (b byte) {
+	if c.err == nil {
+		b, c.err = c.r.ReadByte()
+	}
+	return
+}
+
+func (c *syncConn) readUvarint() (n uint64) {
+	if c.err == nil {
+		n, c.err = binary.ReadUvarint(c.r)
+	}
+	return
+}
+
+func (c *syncConn) readRaw(n uint64) (b []byte) {
+	if c.err == nil {
+		if n > maxSyncBytes {
+			c.err = BadSyncMessage
+		} else {
+			b = make([]byte, n)
+			_, c.err = io.ReadFull(c.r, b)
+		}
+	}
+	return
+}
+
+// Read a byte string preceded by its length.
+func (c *syncConn) readBytes() []byte {
+	return c.readRaw(c.readUvarint())
+}
+
+func (c *syncConn) readDigest() []byte {
+	return c.readRaw(sha256.Size)
+}
+
+// SERVER ///////////////////////////////////////////////////////////
+
+// Answer sync requests from a replica calling PullSync until it ends
+// the session or an error occurs.
+func (root *Root) serveSync(rw io.ReadWriter) (err error) {
+	if !root.merkle {
+		return NotMerkleHAMT
+	}
+	c := newSyncConn(rw)
+	for c.err == nil {
+		switch c.readByte() {
+		case syncReqRoot:
+			c.writeUvarint(uint64(len(root.schedule)))
+			for i := 0; i < len(root.schedule); i++ {
+				c.writeUvarint(uint64(root.schedule[i]))
+			}
+			c.writeUvarint(uint64(root.t))
+			c.writeRaw(root.tree[1])
+		case syncReqTree:
+			i := c.readUvarint()
+			if c.err == nil {
+				if i == 0 || i >= uint64(root.slotCount) {
+					c.err = BadSyncMessage
+				} else {
+					c.writeRaw(root.tree[2*i])
+					c.writeRaw(root.tree[2*i+1])
+				}
+			}
+		case syncReqNode:
+			node := root.readPath(c)
+			root.writeNode(c, node)
+		case syncReqDone:
+			return
+		default:
+			if c.err == nil {
+				c.err = BadSyncMessage
+			}
+		}
+		c.flush()
+	}
+	return c.err
+}
+
+// Read a path, a Root slot number followed by the indices of slots in
+// successive Tables, and return the node it leads to, nil if there is
+// none.  An index beyond the slots of its Table is a BadSyncMessage.
+func (root *Root) readPath(c *syncConn) (node HTNodeI) {
+	ndx := c.readUvarint()
+	ndxs := c.readBytes()
+	if c.err == nil {
+		if ndx >= uint64(root.slotCount) ||
+			uint(len(ndxs)) > root.maxTableDepth {
+			c.err = BadSyncMessage
+		} else {
+			node = root.slot(ndx)
+			for i := 0; i < len(ndxs) && node != nil; i++ {
+				if node.IsLeaf() {
+					node = nil
+				} else if table := node.(*Table); ndxs[i] > table.mask {
+					node = nil
+					c.err = BadSyncMessage
+				} else {
+					node = table.childAt(uint64(ndxs[i]))
+				}
+			}
+		}
+	}
+	return
+}
+
+// Describe a node: its key and value if it is a leaf, the indices and
+// digests of its children if it is a Table.
+func (root *Root) writeNode(c *syncConn, node HTNodeI) {
+	if node == nil {
+		c.writeByte(syncEmpty)
+	} else if node.IsLeaf() {
+		leaf := node.(*Leaf)
+		kBytes, err := keyBytes(leaf.Key)
+		if err == nil {
+			var vBytes []byte
+			vBytes, err = valueBytes(leaf.Value)
+			if err == nil {
+				c.writeByte(syncLeaf)
+				c.writeBytes(kBytes)
+				c.writeBytes(vBytes)
+			}
+		}
+		if c.err == nil {
+			c.err = err
+		}
+	} else {
+		table := node.(*Table)
+		c.writeByte(syncTable)
+		c.writeUvarint(uint64(table.usedSlots()))
+		for ndx := uint64(0); ndx <= uint64(table.mask); ndx++ {
+			if child := table.childAt(ndx); child != nil {
+				c.writeUvarint(ndx)
+				c.writeRaw(nodeDigest(child))
+			}
+		}
+	}
+}
+
+// CLIENT ///////////////////////////////////////////////////////////
+
+// The state of a pull: changes to be made to the local replica are
+// collected while walking the trie and applied afterwards.
+type syncPull struct {
+	root    *Root
+	c       *syncConn
+	deletes []KeyI
+	inserts []*Leaf
+}
+
+// Make this replica identical to the one served at the other end of
+// the connection.  Return the number of keys inserted, replaced, or
+// deleted.  Values fetched from the other replica are stored as byte
+// slices.
+func (root *Root) pullSync(rw io.ReadWriter) (changes uint, err error) {
+	if !root.merkle {
+		return 0, NotMerkleHAMT
+	}
+	p := &syncPull{root: root, c: newSyncConn(rw)}
+	c := p.c
+
+	c.writeByte(syncReqRoot)
+	c.flush()
+	count := c.readUvarint()
+	if c.err == nil && (count == 0 || count > 64) {
+		c.err = BadSyncMessage
+	}
+	var schedule []uint
+	for i := uint64(0); c.err == nil && i < count; i++ {
+		schedule = append(schedule, uint(c.readUvarint()))
+	}
+	t := c.readUvarint()
+	top := c.readDigest()
+	if c.err == nil && (!sameWidths(schedule, root.schedule) ||
+		t != uint64(root.t)) {
+		c.err = MismatchedReplicas
+	}
+	if c.err == nil && !bytes.Equal(top, root.tree[1]) {
+		p.walkTree(1)
+	}
+	c.writeByte(syncReqDone)
+	c.flush()
+	err = c.err
+
+	// apply the changes; no key is both deleted and inserted
+	for i := 0; err == nil && i < len(p.deletes); i++ {
+		err = root.deleteLeaf(p.deletes[i])
+	}
+	for i := 0; err == nil && i < len(p.inserts); i++ {
+		leaf := p.inserts[i]
+		err = root.insertLeaf(leaf.Key, leaf.Value, leaf.digest)
+	}
+	if err == nil {
+		changes = uint(len(p.deletes) + len(p.inserts))
+		if !bytes.Equal(top, root.tree[1]) {
+			// the other replica changed while we were syncing
+			err = SyncIncomplete
+		}
+	}
+	return
+}
+
+// Descend the binary tree over the Root's slots from node i, whose
+// digest differs from that of the other replica.
+func (p *syncPull) walkTree(i uint) {
+	root := p.root
+	if i >= root.slotCount {
+		ndx := i - root.slotCount
+		p.walkNode([]byte{}, ndx, root.t, root.slot(uint64(ndx)))
+		return
+	}
+	p.c.writeByte(syncReqTree)
+	p.c.writeUvarint(uint64(i))
+	p.c.flush()
+	left := p.c.readDigest()
+	right := p.c.readDigest()
+	if p.c.err == nil && !bytes.Equal(left, root.tree[2*i]) {
+		p.walkTree(2 * i)
+	}
+	if p.c.err == nil && !bytes.Equal(right, root.tree[2*i+1]) {
+		p.walkTree(2*i + 1)
+	}
+}
+
+// Compare the local node with the node at the same path in the other
+// replica, which is known to differ.  ndx is the Root slot and ndxs the
+// Table slot indices along the path; shift is the number of hashcode
+// bits used to reach the node.  local may be nil, a leaf, or a Table.
+func (p *syncPull) walkNode(ndxs []byte, ndx uint, shift uint, local HTNodeI) {
+	c := p.c
+	c.writeByte(syncReqNode)
+	c.writeUvarint(uint64(ndx))
+	c.writeBytes(ndxs)
+	c.flush()
+	kind := c.readByte()
+	if c.err != nil {
+		return
+	}
+	switch kind {
+	case syncEmpty:
+		p.deleteAll(local)
+
+	case syncLeaf:
+		kBytes := c.readBytes()
+		vBytes := c.readBytes()
+		if c.err != nil {
+			return
+		}
+		key, err := NewBytesKey(kBytes)
+		var leaf *Leaf
+		if err == nil {
+			leaf, err = NewLeaf(key, vBytes)
+		}
+		if err == nil {
+			leaf.digest, err = leafDigest(key, vBytes)
+		}
+		if err != nil {
+			c.err = err
+			return
+		}
+		// drop any local leaves holding other keys
+		var found bool
+		locals := collectLeaves(local, nil)
+		for i := 0; i < len(locals); i++ {
+			if bytes.Equal(locals[i].digest, leaf.digest) {
+				found = true
+			} else {
+				lBytes, _ := keyBytes(locals[i].Key)
+				if !bytes.Equal(lBytes, kBytes) {
+					p.deletes = append(p.deletes, locals[i].Key)
+				}
+			}
+		}
+		if !found {
+			p.inserts = append(p.inserts, leaf)
+		}
+
+	case syncTable:
+		count := c.readUvarint()
+		if c.err == nil && (count == 0 || uint(len(ndxs)) >= p.root.maxTableDepth) {
+			c.err = BadSyncMessage
+		}
+		remote := make(map[uint64][]byte)
+		for i := uint64(0); c.err == nil && i < count; i++ {
+			n := c.readUvarint()
+			remote[n] = c.readDigest()
+		}
+		if c.err != nil {
+			return
+		}
+		w := p.root.widths[len(ndxs)+1]
+		var localTable *Table
+		var localLeaf *Leaf
+		if local != nil {
+			if local.IsLeaf() {
+				localLeaf = local.(*Leaf)
+			} else {
+				localTable = local.(*Table)
+			}
+		}
+		// children which are only in the local replica go
+		if localTable != nil {
+			for n := uint64(0); n <= uint64(localTable.mask); n++ {
+				if _, ok := remote[n]; !ok {
+					p.deleteAll(localTable.childAt(n))
+				}
+			}
+		} else if localLeaf != nil {
+			n := (localLeaf.Key.Hashcode() >> shift) & (uint64(1)<<w - 1)
+			if _, ok := remote[n]; !ok {
+				p.deletes = append(p.deletes, localLeaf.Key)
+			}
+		}
+		// descend into children which differ, in index order
+		for n := uint64(0); c.err == nil && n < uint64(1)<<w; n++ {
+			digest, ok := remote[n]
+			if !ok {
+				continue
+			}
+			var child HTNodeI
+			if localTable != nil {
+				child = localTable.childAt(n)
+			} else if localLeaf != nil {
+				hc := localLeaf.Key.Hashcode() >> shift
+				if hc&(uint64(1)<<w-1) == n {
+					child = localLeaf
+				}
+			}
+			if child == nil || !bytes.Equal(nodeDigest(child), digest) {
+				p.walkNode(append(ndxs[:len(ndxs):len(ndxs)], byte(n)),
+					ndx, shift+w, child)
+			}
+		}
+
+	default:
+		c.err = BadSyncMessage
+	}
+}
+
+// Schedule the deletion of every leaf at or below node.
+func (p *syncPull) deleteAll(node HTNodeI) {
+	leaves := collectLeaves(node, nil)
+	for i := 0; i < len(leaves); i++ {
+		p.deletes = append(p.deletes, leaves[i].Key)
+	}
+}
+
+// Append the leaves at or below node to leaves.
+func collectLeaves(node HTNodeI, leaves []*Leaf) []*Leaf {
+	if node != nil {
+		if node.IsLeaf() {
+			leaves = append(leaves, node.(*Leaf))
+		} else {
+			table := node.(*Table)
+			for i := 0; i < len(table.keys); i++ {
+				leaves = append(leaves, table.leafAt(i))
+			}
+			for i := 0; i < len(table.nodes); i++ {
+				leaves = collectLeaves(table.nodes[i], leaves)
+			}
+		}
+	}
+	return leaves
+}
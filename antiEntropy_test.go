@@ -0,0 +1,221 @@
+package hamt_go
+
+// hamt_go/antiEntropy_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"io"
+	"net"
+)
+
+var _ = fmt.Print
+
+// A connection which counts the bytes read through it.
+type countingConn struct {
+	net.Conn
+	count int
+}
+
+func (cc *countingConn) Read(b []byte) (n int, err error) {
+	n, err = cc.Conn.Read(b)
+	cc.count += n
+	return
+}
+
+// Pull server's state into client over an in-process pipe.  Return the
+// number of changes made and the number of bytes received by client.
+func (s *XLSuite) doSync(c *C, server, client HAMT) (
+	changes uint, received int, err error) {
+
+	srvEnd, cliEnd := net.Pipe()
+	defer srvEnd.Close()
+	defer cliEnd.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ServeSync(srvEnd)
+	}()
+	conn := &countingConn{Conn: cliEnd}
+	changes, err = client.PullSync(conn)
+	if err == nil {
+		err = <-done
+	}
+	received = conn.count
+	return
+}
+
+func (s *XLSuite) TestAntiEntropySync(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ANTI_ENTROPY_SYNC")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestAntiEntropySync(c, rng, 4, 4)
+	s.doTestAntiEntropySync(c, rng, 5, 8)
+	s.doTestAntiEntropySync(c, rng, 6, 12)
+	s.doTestAntiEntropySync(c, rng, 8, 8)
+}
+
+func (s *XLSuite) doTestAntiEntropySync(c *C, rng *xr.PRNG, w, t uint) {
+	const KEY_COUNT = 4096
+	const DIFF_COUNT = 8
+
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT+DIFF_COUNT, 16)
+
+	a, err := NewMerkleHAMT(w, t)
+	c.Assert(err, IsNil)
+	b, err := NewMerkleHAMT(w, t)
+	c.Assert(err, IsNil)
+
+	// b starts out empty, so everything must be copied
+	for i := 0; i < KEY_COUNT; i++ {
+		err = a.Insert(bKeys[i], &rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	changes, fullSize, err := s.doSync(c, a, b)
+	c.Assert(err, IsNil)
+	c.Assert(changes, Equals, uint(KEY_COUNT))
+	c.Assert(bytes.Equal(a.RootDigest(), b.RootDigest()), Equals, true)
+	c.Assert(b.GetLeafCount(), Equals, uint(KEY_COUNT))
+
+	// identical replicas exchange next to nothing
+	changes, received, err := s.doSync(c, a, b)
+	c.Assert(err, IsNil)
+	c.Assert(changes, Equals, uint(0))
+	c.Assert(received < 64, Equals, true)
+
+	// a now differs from b in a few keys: some are added, some deleted,
+	// some have new values
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < DIFF_COUNT; i++ {
+		err = a.Insert(bKeys[KEY_COUNT+i], &rawKeys[KEY_COUNT+i])
+		c.Assert(err, IsNil)
+		err = a.Delete(bKeys[perm[i]])
+		c.Assert(err, IsNil)
+		err = a.Insert(bKeys[perm[DIFF_COUNT+i]], []byte("new value"))
+		c.Assert(err, IsNil)
+	}
+	changes, received, err = s.doSync(c, a, b)
+	c.Assert(err, IsNil)
+	c.Assert(changes, Equals, uint(3*DIFF_COUNT))
+	c.Assert(bytes.Equal(a.RootDigest(), b.RootDigest()), Equals, true)
+	c.Assert(b.GetLeafCount(), Equals, a.GetLeafCount())
+	c.Assert(received < fullSize/4, Equals, true)
+
+	for i := 0; i < DIFF_COUNT; i++ {
+		value, err := b.Find(bKeys[perm[i]])
+		c.Assert(err, IsNil)
+		c.Assert(value, IsNil)
+		value, err = b.Find(bKeys[KEY_COUNT+i])
+		c.Assert(err, IsNil)
+		c.Assert(bytes.Equal(value.([]byte), rawKeys[KEY_COUNT+i]), Equals, true)
+		value, err = b.Find(bKeys[perm[DIFF_COUNT+i]])
+		c.Assert(err, IsNil)
+		c.Assert(string(value.([]byte)), Equals, "new value")
+	}
+
+	// pulling from an empty replica empties this one
+	empty, err := NewMerkleHAMT(w, t)
+	c.Assert(err, IsNil)
+	_, _, err = s.doSync(c, empty, b)
+	c.Assert(err, IsNil)
+	c.Assert(b.GetLeafCount(), Equals, uint(0))
+	c.Assert(b.GetTableCount(), Equals, uint(1))
+	c.Assert(bytes.Equal(empty.RootDigest(), b.RootDigest()), Equals, true)
+}
+
+// Keys sharing long hashcode prefixes force the sync to descend through
+// chains of tables, some present in only one of the replicas.
+func (s *XLSuite) TestAntiEntropyLongPaths(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ANTI_ENTROPY_LONG_PATHS")
+	}
+	rng := xr.MakeSimpleRNG()
+	w := uint(5)
+	_, rawKeys := s.makePermutedKeys(rng, w)
+	KEY_COUNT := 64 / w
+
+	a, err := NewMerkleHAMT(w, w)
+	c.Assert(err, IsNil)
+	b, err := NewMerkleHAMT(w, w)
+	c.Assert(err, IsNil)
+	for i := uint(0); i < KEY_COUNT; i++ {
+		bKey, err := NewBytesKey(rawKeys[i])
+		c.Assert(err, IsNil)
+		if i%2 == 0 {
+			err = a.Insert(bKey, rawKeys[i])
+		} else {
+			err = b.Insert(bKey, rawKeys[i])
+		}
+		c.Assert(err, IsNil)
+	}
+	_, _, err = s.doSync(c, a, b)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(a.RootDigest(), b.RootDigest()), Equals, true)
+	c.Assert(b.GetLeafCount(), Equals, a.GetLeafCount())
+	c.Assert(b.GetTableCount(), Equals, a.GetTableCount())
+}
+
+func (s *XLSuite) TestAntiEntropyMismatches(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ANTI_ENTROPY_MISMATCHES")
+	}
+	a, err := NewMerkleHAMT(5, 5)
+	c.Assert(err, IsNil)
+	b, err := NewMerkleHAMT(5, 6)
+	c.Assert(err, IsNil)
+	_, _, err = s.doSync(c, a, b)
+	c.Assert(err, Equals, MismatchedReplicas)
+
+	plain, err := NewHAMT(5, 5)
+	c.Assert(err, IsNil)
+	_, err = plain.PullSync(nil)
+	c.Assert(err, Equals, NotMerkleHAMT)
+	err = plain.ServeSync(nil)
+	c.Assert(err, Equals, NotMerkleHAMT)
+}
+
+// Send ServeSync a request for the node at a path of one index below
+// Root slot 0, then end the session; return what ServeSync returns.
+func (s *XLSuite) servePath(server HAMT, ndx byte) error {
+	var in, out bytes.Buffer
+	in.WriteByte(syncReqNode)
+	in.WriteByte(0)          // Root slot, as a uvarint
+	in.Write([]byte{1, ndx}) // length of the path, then the index
+	in.WriteByte(syncReqDone)
+	return server.ServeSync(struct {
+		io.Reader
+		io.Writer
+	}{&in, &out})
+}
+
+func (s *XLSuite) TestAntiEntropyBadPath(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ANTI_ENTROPY_BAD_PATH")
+	}
+	rawKeys, bKeys := makeSomeUniqueKeys(256, 16)
+	for _, w := range []uint{5, 6, 8} {
+		// with a root of four slots, every slot holds a Table
+		h, err := NewMerkleHAMT(w, 2)
+		c.Assert(err, IsNil)
+		for i := 0; i < len(bKeys); i++ {
+			c.Assert(h.Insert(bKeys[i], rawKeys[i]), IsNil)
+		}
+		table, ok := h.root.slot(0).(*Table)
+		c.Assert(ok, Equals, true)
+		if w <= maxWordW {
+			// slots beyond a narrow table's are simply empty
+			c.Assert(table.childAt(64), IsNil)
+			c.Assert(table.childAt(200), IsNil)
+		}
+		for _, ndx := range []int{0, 31, 40, 63, 64, 127, 128, 200, 255} {
+			err = s.servePath(h, byte(ndx))
+			if ndx < 1<<w {
+				c.Assert(err, IsNil)
+			} else {
+				c.Assert(err, Equals, BadSyncMessage)
+			}
+		}
+	}
+}
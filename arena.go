@@ -0,0 +1,440 @@
+package hamt_go
+
+// hamt_go/arena.go
+
+import (
+	"math"
+	"math/bits"
+)
+
+// An ArenaHAMT is a HAMT whose tables are not separate Go objects.
+// All tables live in one large slice and refer to their children by
+// uint32 index rather than by pointer; the references held by each
+// table live in a block carved out of one large slice of uint32s; and
+// keys and values are held in a single pair of parallel slices.
+// Tables, blocks, and entries freed by deletions go onto free lists
+// for reuse.  However many entries there are, the garbage collector
+// then sees a handful of big arrays, only two of them, the keys and
+// the values, holding any pointers, rather than millions of small
+// objects.
+//
+// An ArenaHAMT is never a Merkle HAMT, and is not thread-safe.
+type ArenaHAMT struct {
+	w, t          uint
+	maxTableDepth uint
+	rootMask      uint64
+	tableMask     uint64
+	rootSlots     []uint32     // 2^t refs, each possibly arenaNil
+	tables        []arenaTable // indexed by table number
+	slots         []uint32     // blocks of refs, one block per table
+	keys          []KeyI       // indexed by entry number
+	values        []interface{}
+	freeTables    []uint32
+	freeBlocks    [maxWordW + 1][]uint32 // block offsets by log2 block size
+	freeEntries   []uint32
+	maxTables     uint32 // beyond which newTable fails; lowered in tests
+}
+
+// A table in an ArenaHAMT.  The refs for the slots in use are held in
+// slot order at the beginning of a block of 1<<sizeLog uint32s in the
+// arena's slots.
+type arenaTable struct {
+	bitmap  uint64 // bit n set if slot n is in use
+	block   uint32 // offset of the table's block in slots
+	sizeLog uint8
+}
+
+// A ref is arenaNil, an entry number plus one, or a table number with
+// arenaTableBit set.
+const (
+	arenaNil      = uint32(0)
+	arenaTableBit = uint32(1) << 31
+	maxArenaRefs  = arenaTableBit - 1
+)
+
+// Create an ArenaHAMT with 2^t slots in its root table and 2^w slots in
+// all lower-level tables.  The parameters are as for NewHAMT.
+func NewArenaHAMT(w, t uint) (a *ArenaHAMT, err error) {
+	if t == 0 && w == 0 {
+		err = ZeroLengthTables
+	} else if w > MAX_W {
+		err = MaxTableSizeExceeded
+	} else if w > maxWordW {
+		err = WideTablesUnsupported
+	} else {
+		if t == 0 {
+			t = w
+		}
+		if w == 0 {
+			err = ZeroLengthTables
+		} else if t > 64 {
+			err = MaxRootTableSizeExceeded
+		} else {
+			a = &ArenaHAMT{
+				w: w,
+				t: t,
+				// as in a HAMT, any bits left over are used by a final
+				// level of tables, of which only the slots those bits
+				// can index are ever used
+				maxTableDepth: (64 - t + w - 1) / w,
+				rootMask:      uint64(1)<<t - 1,
+				tableMask:     uint64(1)<<w - 1,
+				rootSlots:     make([]uint32, uint64(1)<<t),
+				maxTables:     maxArenaRefs,
+			}
+		}
+	}
+	return
+}
+
+// Return the number of entries in the HAMT.
+func (a *ArenaHAMT) GetLeafCount() uint {
+	return uint(len(a.keys) - len(a.freeEntries))
+}
+
+// Return the number of tables in the HAMT, including the root.
+func (a *ArenaHAMT) GetTableCount() uint {
+	return uint(1 + len(a.tables) - len(a.freeTables))
+}
+
+// ARENA MANAGEMENT /////////////////////////////////////////////////
+
+// Store an entry, returning its ref.
+func (a *ArenaHAMT) newEntry(k KeyI, v interface{}) (ref uint32, err error) {
+	if n := len(a.freeEntries); n > 0 {
+		e := a.freeEntries[n-1]
+		a.freeEntries = a.freeEntries[:n-1]
+		a.keys[e], a.values[e] = k, v
+		ref = e + 1
+	} else if uint32(len(a.keys)) >= maxArenaRefs {
+		err = ArenaFull
+	} else {
+		a.keys = append(a.keys, k)
+		a.values = append(a.values, v)
+		ref = uint32(len(a.keys))
+	}
+	return
+}
+
+func (a *ArenaHAMT) freeEntry(ref uint32) {
+	e := ref - 1
+	a.keys[e], a.values[e] = nil, nil // let the garbage collector have them
+	a.freeEntries = append(a.freeEntries, e)
+}
+
+// Return the offset in slots of a free block of 1<<sizeLog refs.
+func (a *ArenaHAMT) allocBlock(sizeLog uint8) (block uint32, err error) {
+	free := a.freeBlocks[sizeLog]
+	if n := len(free); n > 0 {
+		block = free[n-1]
+		a.freeBlocks[sizeLog] = free[:n-1]
+	} else if uint64(len(a.slots))+uint64(1)<<sizeLog > math.MaxUint32 {
+		err = ArenaFull
+	} else {
+		block = uint32(len(a.slots))
+		a.slots = append(a.slots, make([]uint32, 1<<sizeLog)...)
+	}
+	return
+}
+
+// Return a new empty table with room for 1<<sizeLog refs.
+func (a *ArenaHAMT) newTable(sizeLog uint8) (ti uint32, err error) {
+	var block uint32
+	if len(a.freeTables) == 0 && uint32(len(a.tables)) >= a.maxTables {
+		err = ArenaFull
+	} else {
+		block, err = a.allocBlock(sizeLog)
+	}
+	if err == nil {
+		tbl := arenaTable{block: block, sizeLog: sizeLog}
+		if n := len(a.freeTables); n > 0 {
+			ti = a.freeTables[n-1]
+			a.freeTables = a.freeTables[:n-1]
+			a.tables[ti] = tbl
+		} else {
+			ti = uint32(len(a.tables))
+			a.tables = append(a.tables, tbl)
+		}
+	}
+	return
+}
+
+func (a *ArenaHAMT) freeTable(ti uint32) {
+	tbl := &a.tables[ti]
+	a.freeBlocks[tbl.sizeLog] = append(a.freeBlocks[tbl.sizeLog], tbl.block)
+	*tbl = arenaTable{}
+	a.freeTables = append(a.freeTables, ti)
+}
+
+// Return the offset in slots of the ref for the slot marked by flag,
+// which must be in use.
+func (a *ArenaHAMT) refOffset(ti uint32, flag uint64) uint32 {
+	tbl := &a.tables[ti]
+	return tbl.block + uint32(bits.OnesCount64(tbl.bitmap&(flag-1)))
+}
+
+// Put a ref in the unused slot marked by flag, moving the table to a
+// larger block if its block is full.
+func (a *ArenaHAMT) insertRef(ti uint32, flag uint64, ref uint32) (err error) {
+	tbl := a.tables[ti]
+	count := uint32(bits.OnesCount64(tbl.bitmap))
+	offset := uint32(bits.OnesCount64(tbl.bitmap & (flag - 1)))
+	old := tbl.block
+	if count == uint32(1)<<tbl.sizeLog {
+		var block uint32
+		block, err = a.allocBlock(tbl.sizeLog + 1)
+		if err == nil {
+			copy(a.slots[block:block+offset], a.slots[old:old+offset])
+			copy(a.slots[block+offset+1:block+count+1], a.slots[old+offset:old+count])
+			a.freeBlocks[tbl.sizeLog] = append(a.freeBlocks[tbl.sizeLog], old)
+			tbl.block = block
+			tbl.sizeLog++
+		}
+	} else {
+		copy(a.slots[old+offset+1:old+count+1], a.slots[old+offset:old+count])
+	}
+	if err == nil {
+		a.slots[tbl.block+offset] = ref
+		tbl.bitmap |= flag
+		a.tables[ti] = tbl
+	}
+	return
+}
+
+// Remove the ref in the slot marked by flag, which must be in use.
+func (a *ArenaHAMT) removeRef(ti uint32, flag uint64) {
+	tbl := &a.tables[ti]
+	count := uint32(bits.OnesCount64(tbl.bitmap))
+	offset := uint32(bits.OnesCount64(tbl.bitmap & (flag - 1)))
+	b := tbl.block
+	copy(a.slots[b+offset:b+count-1], a.slots[b+offset+1:b+count])
+	a.slots[b+count-1] = arenaNil
+	tbl.bitmap &= ^flag
+}
+
+// TRIE OPERATIONS //////////////////////////////////////////////////
+
+// If there is an entry with the key k in the HAMT, return the value
+// associated with the key.  If there is no such entry, return nil.
+func (a *ArenaHAMT) Find(k KeyI) (value interface{}, err error) {
+	if k == nil {
+		err = NilKey
+		return
+	}
+	hc := k.Hashcode()
+	ref := a.rootSlots[hc&a.rootMask]
+	hc >>= a.t
+	for ref&arenaTableBit != 0 {
+		tbl := &a.tables[ref&^arenaTableBit]
+		flag := uint64(1) << (hc & a.tableMask)
+		if tbl.bitmap&flag == 0 {
+			return
+		}
+		ref = a.slots[tbl.block+uint32(bits.OnesCount64(tbl.bitmap&(flag-1)))]
+		hc >>= a.w
+	}
+	if ref != arenaNil {
+		if sameKey(a.keys[ref-1], k) {
+			value = a.values[ref-1]
+		}
+	}
+	return
+}
+
+// Insert the key/value pair into the HAMT, replacing any value already
+// associated with the key.  Neither the key nor the value may be nil.
+func (a *ArenaHAMT) Insert(k KeyI, v interface{}) (err error) {
+	if k == nil {
+		return NilKey
+	} else if v == nil {
+		return NilValue
+	}
+	hc := k.Hashcode()
+	rootNdx := hc & a.rootMask
+	ref := a.rootSlots[rootNdx]
+	hc >>= a.t
+
+	// parent is the table holding ref, and flag marks ref's slot in it;
+	// while ref is in the root, parent is arenaNil
+	parent, flag := arenaNil, uint64(0)
+	for depth := uint(1); ; depth++ {
+		if ref == arenaNil {
+			ref, err = a.newEntry(k, v)
+			if err == nil {
+				a.rootSlots[rootNdx] = ref
+			}
+			return
+		}
+		if ref&arenaTableBit == 0 {
+			if sameKey(a.keys[ref-1], k) {
+				// the keys match, so we replace the value
+				a.values[ref-1] = v
+				return
+			}
+			// keys differ, so the entry is replaced by a table
+			var tRef uint32
+			tRef, err = a.split(ref, k, v, hc, depth)
+			if err == nil {
+				if parent == arenaNil {
+					a.rootSlots[rootNdx] = tRef
+				} else {
+					a.slots[a.refOffset(parent&^arenaTableBit, flag)] = tRef
+				}
+			}
+			return
+		}
+		// ref is a table
+		ti := ref &^ arenaTableBit
+		flag = uint64(1) << (hc & a.tableMask)
+		if a.tables[ti].bitmap&flag == 0 {
+			ref, err = a.newEntry(k, v)
+			if err == nil {
+				err = a.insertRef(ti, flag, ref)
+				if err != nil {
+					a.freeEntry(ref)
+				}
+			}
+			return
+		}
+		parent = ref
+		ref = a.slots[a.refOffset(ti, flag)]
+		hc >>= a.w
+	}
+}
+
+// Build the tables which replace the entry ref at depth-1 when a new
+// entry with a different key but hashcode hc, shifted for depth, is
+// inserted: a chain of single-slot tables, one for each level at which
+// the two hashcodes agree, ending in a table holding both entries.
+// Return the ref of the table at the top of the chain.
+func (a *ArenaHAMT) split(ref uint32, k KeyI, v interface{}, hc uint64,
+	depth uint) (tRef uint32, err error) {
+
+	oldHC := a.keys[ref-1].Hashcode() >> (a.t + (depth-1)*a.w)
+	levels := uint(0) // number of single-slot tables
+	for err == nil && (oldHC^hc)>>(levels*a.w)&a.tableMask == 0 {
+		levels++
+		if levels*a.w >= 64 {
+			err = MaxTableDepthExceeded
+		}
+	}
+	if err == nil && depth+levels > a.maxTableDepth {
+		err = MaxTableDepthExceeded
+	}
+	var newRef, ti uint32
+	if err == nil {
+		newRef, err = a.newEntry(k, v)
+	}
+	if err == nil {
+		ti, err = a.newTable(1)
+		if err != nil {
+			a.freeEntry(newRef)
+		}
+	}
+	if err == nil {
+		shift := levels * a.w
+		oldNdx := (oldHC >> shift) & a.tableMask
+		newNdx := (hc >> shift) & a.tableMask
+		tbl := &a.tables[ti]
+		tbl.bitmap = uint64(1)<<oldNdx | uint64(1)<<newNdx
+		if oldNdx < newNdx {
+			a.slots[tbl.block], a.slots[tbl.block+1] = ref, newRef
+		} else {
+			a.slots[tbl.block], a.slots[tbl.block+1] = newRef, ref
+		}
+		tRef = arenaTableBit | ti
+		for levels > 0 && err == nil {
+			levels--
+			shift -= a.w
+			ti, err = a.newTable(0)
+			if err == nil {
+				tbl = &a.tables[ti]
+				tbl.bitmap = uint64(1) << ((hc >> shift) & a.tableMask)
+				a.slots[tbl.block] = tRef
+				tRef = arenaTableBit | ti
+			}
+		}
+		if err != nil {
+			a.freeChain(tRef)
+			a.freeEntry(newRef)
+			tRef = arenaNil
+		}
+	}
+	return
+}
+
+// Free a chain of tables built by split which could not be completed,
+// from the table whose ref is tRef down to and including the table
+// holding both entries.  The entries themselves are left alone.
+func (a *ArenaHAMT) freeChain(tRef uint32) {
+	for tRef&arenaTableBit != 0 {
+		ti := tRef &^ arenaTableBit
+		tbl := a.tables[ti]
+		tRef = a.slots[tbl.block] // the next table down, or an entry
+		for i := uint32(0); i < uint32(1)<<tbl.sizeLog; i++ {
+			a.slots[tbl.block+i] = arenaNil
+		}
+		a.freeTable(ti)
+	}
+}
+
+// If there is an entry with the key k in the HAMT, remove it.  If
+// there is no such entry, return NotFound.  As in HAMT, a table left
+// empty or holding a single entry is removed, with its entry pulled up
+// into its parent, so that the trie stays in canonical form.
+func (a *ArenaHAMT) Delete(k KeyI) (err error) {
+	if k == nil {
+		return NilKey
+	}
+	hc := k.Hashcode()
+	rootNdx := hc & a.rootMask
+	ref := a.rootSlots[rootNdx]
+	hc >>= a.t
+
+	// the tables on the path to the entry and the slots used in them
+	var path [64]uint32
+	var flags [64]uint64
+	n := 0
+	for ref&arenaTableBit != 0 {
+		ti := ref &^ arenaTableBit
+		flag := uint64(1) << (hc & a.tableMask)
+		if a.tables[ti].bitmap&flag == 0 {
+			return NotFound
+		}
+		path[n], flags[n] = ti, flag
+		n++
+		ref = a.slots[a.refOffset(ti, flag)]
+		hc >>= a.w
+	}
+	if ref == arenaNil {
+		return NotFound
+	}
+	if !sameKey(a.keys[ref-1], k) {
+		return NotFound
+	}
+	a.freeEntry(ref)
+
+	// replacement is what takes the place of the ref just removed
+	replacement := arenaNil
+	for n > 0 {
+		n--
+		ti, flag := path[n], flags[n]
+		if replacement == arenaNil {
+			a.removeRef(ti, flag)
+		} else {
+			a.slots[a.refOffset(ti, flag)] = replacement
+		}
+		tbl := &a.tables[ti]
+		if tbl.bitmap == 0 {
+			replacement = arenaNil
+		} else if tbl.bitmap&(tbl.bitmap-1) == 0 &&
+			a.slots[tbl.block]&arenaTableBit == 0 {
+			replacement = a.slots[tbl.block]
+		} else {
+			return // this table stays, so everything above is unchanged
+		}
+		a.freeTable(ti)
+	}
+	a.rootSlots[rootNdx] = replacement
+	return
+}
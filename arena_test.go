@@ -0,0 +1,188 @@
+package hamt_go
+
+// hamt_go/arena_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestArenaCtor(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ARENA_CTOR")
+	}
+	_, err := NewArenaHAMT(0, 0)
+	c.Assert(err, Equals, ZeroLengthTables)
+	_, err = NewArenaHAMT(MAX_W+1, 8)
+	c.Assert(err, Equals, MaxTableSizeExceeded)
+	_, err = NewArenaHAMT(maxWordW+1, 8)
+	c.Assert(err, Equals, WideTablesUnsupported)
+	a, err := NewArenaHAMT(5, 0)
+	c.Assert(err, IsNil)
+	c.Assert(a.t, Equals, uint(5))
+	c.Assert(a.GetLeafCount(), Equals, uint(0))
+	c.Assert(a.GetTableCount(), Equals, uint(1))
+
+	c.Assert(a.Insert(nil, "x"), Equals, NilKey)
+	c.Assert(a.Insert(BytesKey{}, nil), Equals, NilValue)
+}
+
+// An ArenaHAMT built from the same keys as a HAMT must hold the same
+// entries in the same number of tables.
+func (s *XLSuite) TestArenaHAMT(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ARENA_HAMT")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestArenaHAMT(c, rng, 4, 4)
+	s.doTestArenaHAMT(c, rng, 5, 8)
+	s.doTestArenaHAMT(c, rng, 6, 12)
+}
+
+func (s *XLSuite) doTestArenaHAMT(c *C, rng *xr.PRNG, w, t uint) {
+	const KEY_COUNT = 4096
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	a, err := NewArenaHAMT(w, t)
+	c.Assert(err, IsNil)
+	h, err := NewHAMT(w, t)
+	c.Assert(err, IsNil)
+
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(a.Insert(bKeys[i], &rawKeys[i]), IsNil)
+		c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+	}
+	c.Assert(a.GetLeafCount(), Equals, uint(KEY_COUNT))
+	c.Assert(a.GetTableCount(), Equals, h.GetTableCount())
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := a.Find(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(bytes.Equal(*value.(*[]byte), rawKeys[i]), Equals, true)
+	}
+	// replacing a value changes nothing else
+	c.Assert(a.Insert(bKeys[0], "new value"), IsNil)
+	value, err := a.Find(bKeys[0])
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "new value")
+	c.Assert(a.GetLeafCount(), Equals, uint(KEY_COUNT))
+
+	// delete half the keys in random order
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT/2; i++ {
+		c.Assert(a.Delete(bKeys[perm[i]]), IsNil)
+		c.Assert(h.Delete(bKeys[perm[i]]), IsNil)
+	}
+	c.Assert(a.Delete(bKeys[perm[0]]), Equals, NotFound)
+	c.Assert(a.GetLeafCount(), Equals, uint(KEY_COUNT/2))
+	c.Assert(a.GetTableCount(), Equals, h.GetTableCount())
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := a.Find(bKeys[perm[i]])
+		c.Assert(err, IsNil)
+		c.Assert(value == nil, Equals, i < KEY_COUNT/2)
+	}
+
+	// delete the rest; then reinserting everything reuses the arena
+	tableHigh, slotHigh := len(a.tables), len(a.slots)
+	for i := KEY_COUNT / 2; i < KEY_COUNT; i++ {
+		c.Assert(a.Delete(bKeys[perm[i]]), IsNil)
+	}
+	c.Assert(a.GetLeafCount(), Equals, uint(0))
+	c.Assert(a.GetTableCount(), Equals, uint(1))
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(a.Insert(bKeys[i], &rawKeys[i]), IsNil)
+	}
+	c.Assert(len(a.keys), Equals, KEY_COUNT)
+	c.Assert(len(a.tables) <= tableHigh, Equals, true)
+	c.Assert(len(a.slots) <= slotHigh, Equals, true)
+}
+
+// Keys sharing long hashcode prefixes build and then collapse chains
+// of single-slot tables.
+func (s *XLSuite) TestArenaLongPaths(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ARENA_LONG_PATHS")
+	}
+	rng := xr.MakeSimpleRNG()
+	for w := uint(4); w <= maxWordW; w++ {
+		_, rawKeys := s.makePermutedKeys(rng, w)
+		KEY_COUNT := 64 / w
+		if KEY_COUNT > uint(len(rawKeys)) {
+			KEY_COUNT = uint(len(rawKeys))
+		}
+		a, err := NewArenaHAMT(w, w)
+		c.Assert(err, IsNil)
+		h, err := NewHAMT(w, w)
+		c.Assert(err, IsNil)
+		bKeys := make([]BytesKey, KEY_COUNT)
+		for i := uint(0); i < KEY_COUNT; i++ {
+			bKeys[i], err = NewBytesKey(rawKeys[i])
+			c.Assert(err, IsNil)
+			c.Assert(a.Insert(bKeys[i], rawKeys[i]), IsNil)
+			c.Assert(h.Insert(bKeys[i], rawKeys[i]), IsNil)
+			c.Assert(a.GetTableCount(), Equals, h.GetTableCount())
+		}
+		perm := rng.Perm(int(KEY_COUNT))
+		for i := 0; i < len(perm); i++ {
+			c.Assert(a.Delete(bKeys[perm[i]]), IsNil)
+			c.Assert(h.Delete(bKeys[perm[i]]), IsNil)
+			c.Assert(a.GetTableCount(), Equals, h.GetTableCount())
+			for j := i + 1; j < len(perm); j++ {
+				value, err := a.Find(bKeys[perm[j]])
+				c.Assert(err, IsNil)
+				c.Assert(bytes.Equal(value.([]byte), rawKeys[perm[j]]), Equals, true)
+			}
+		}
+		c.Assert(a.GetLeafCount(), Equals, uint(0))
+		c.Assert(a.GetTableCount(), Equals, uint(1))
+	}
+}
+
+// A split which fills the arena partway through frees whatever it has
+// built, so that failed inserts leak nothing.
+func (s *XLSuite) TestArenaFullSplit(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ARENA_FULL_SPLIT")
+	}
+	a, err := NewArenaHAMT(4, 4)
+	c.Assert(err, IsNil)
+	// the two keys agree in the root slot and at five levels below it,
+	// so that the second needs a chain of six tables
+	first := hc32Key{n: 1, hc: 0}
+	second := hc32Key{n: 2, hc: uint64(1) << (4 + 5*4)}
+	c.Assert(a.Insert(first, "first"), IsNil)
+	a.maxTables = 3
+
+	for i := 0; i < 3; i++ {
+		c.Assert(a.Insert(second, "second"), Equals, ArenaFull)
+		c.Assert(a.GetTableCount(), Equals, uint(1))
+		c.Assert(a.GetLeafCount(), Equals, uint(1))
+		// the tables and entry built are reused by the next attempt
+		c.Assert(len(a.tables), Equals, 3)
+		c.Assert(len(a.freeTables), Equals, 3)
+		c.Assert(len(a.keys), Equals, 2)
+		c.Assert(len(a.freeEntries), Equals, 1)
+		value, err := a.Find(first)
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, "first")
+		value, err = a.Find(second)
+		c.Assert(err, IsNil)
+		c.Assert(value, IsNil)
+	}
+
+	// given room, the same insert succeeds, reusing what was freed
+	a.maxTables = 6
+	c.Assert(a.Insert(second, "second"), IsNil)
+	c.Assert(a.GetTableCount(), Equals, uint(7))
+	c.Assert(len(a.tables), Equals, 6)
+	c.Assert(len(a.keys), Equals, 2)
+	value, err := a.Find(second)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "second")
+	c.Assert(a.Delete(first), IsNil)
+	c.Assert(a.Delete(second), IsNil)
+	c.Assert(a.GetTableCount(), Equals, uint(1))
+}
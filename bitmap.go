@@ -0,0 +1,104 @@
+package hamt_go
+
+// hamt_go/bitmap.go
+
+import (
+	"math/bits"
+)
+
+// A Table with w of at most 6 has no more than 64 slots, and its
+// bitmaps are single uint64s.  A wider Table, with w of 7 or 8, keeps
+// the bits for its first 64 slots in the same uint64s and those for
+// the rest in wideMaps, one word per 64 slots.  Each extra word carries
+// the number of bits set in all the words below it, so that the offset
+// of a slot's entry or subtable is still found with one popcount.
+
+const (
+	maxWordW       = uint(6) // widest Table needing no wideMaps
+	maxBitmapWords = 1 << (MAX_W - maxWordW)
+)
+
+type wideMap struct {
+	words  [maxBitmapWords - 1]uint64 // slots 64 and up
+	before [maxBitmapWords - 1]uint8  // bits set in all lower words
+}
+
+// The bitmaps for slots 64 and up in a Table with w > 6.
+type wideMaps struct {
+	data wideMap
+	node wideMap
+}
+
+// A Table with w > 6 and its wideMaps, allocated together.
+type wideTable struct {
+	Table
+	wideMaps
+}
+
+// Return the offset of slot ndx, which must be at least 64, among the
+// slots whose bits are set, and whether its own bit is set.
+func (m *wideMap) slot(ndx uint64) (uint, bool) {
+	i := ndx>>6 - 1
+	flag := uint64(1) << (ndx & 63)
+	return uint(m.before[i]) + uint(bits.OnesCount64(m.words[i]&(flag-1))),
+		m.words[i]&flag != 0
+}
+
+// Return the data and node bitmap words holding the bits for slot ndx,
+// which must be at least 64, and the bits set in all lower words.
+func (m *wideMaps) words(ndx uint64) (dataMap, nodeMap uint64,
+	dataBase, nodeBase uint) {
+
+	i := ndx>>6 - 1
+	return m.data.words[i], m.node.words[i],
+		uint(m.data.before[i]), uint(m.node.before[i])
+}
+
+// Set (if delta is 1) or clear (if delta is -1) the bit for slot ndx,
+// word0 holding the bits for the first 64 slots, and adjust the
+// cumulative counts of the words above.
+func (m *wideMap) change(word0 *uint64, ndx uint64, delta int) {
+	i := ndx >> 6
+	flag := uint64(1) << (ndx & 63)
+	word := word0
+	if i > 0 {
+		word = &m.words[i-1]
+	}
+	if delta > 0 {
+		*word |= flag
+	} else {
+		*word &= ^flag
+	}
+	for j := i; j < uint64(len(m.before)); j++ {
+		m.before[j] = uint8(int(m.before[j]) + delta)
+	}
+}
+
+// Return the number of words in a bitmap for 2^w slots.
+func bitmapWordCount(w uint) int {
+	if w <= maxWordW {
+		return 1
+	}
+	return 1 << (w - maxWordW)
+}
+
+// Return whether bit n is set in a multi-word bitmap.
+func bitmapTest(words []uint64, n uint64) bool {
+	return words[n>>6]&(uint64(1)<<(n&63)) != 0
+}
+
+// Return the number of bits below bit n set in a multi-word bitmap.
+func bitmapRank(words []uint64, n uint64) (count int) {
+	for i := uint64(0); i < n>>6; i++ {
+		count += bits.OnesCount64(words[i])
+	}
+	return count + bits.OnesCount64(words[n>>6]&(uint64(1)<<(n&63)-1))
+}
+
+// Return the number of bits set in a multi-word bitmap.
+func bitmapCount(words []uint64) (count int) {
+	for i := 0; i < len(words); i++ {
+		count += bits.OnesCount64(words[i])
+	}
+	return
+}
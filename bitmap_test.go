@@ -0,0 +1,60 @@
+package hamt_go
+
+// hamt_go/bitmap_test.go
+
+import (
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestWideTableBitmaps(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_WIDE_TABLE_BITMAPS")
+	}
+	rng := xr.MakeSimpleRNG()
+	for w := uint(4); w <= MAX_W; w++ {
+		s.doTestWideTableBitmaps(c, rng, w)
+	}
+}
+
+func (s *XLSuite) doTestWideTableBitmaps(c *C, rng *xr.PRNG, w uint) {
+	root, err := NewRoot(w, 4)
+	c.Assert(err, IsNil)
+	table, err := NewTable(1, root)
+	c.Assert(err, IsNil)
+	c.Assert(table.wide != nil, Equals, w > maxWordW)
+
+	slotCount := 1 << w
+	set := make([]bool, slotCount)
+	for i := 0; i < 4*slotCount; i++ {
+		ndx := uint64(rng.Intn(slotCount))
+		if set[ndx] {
+			table.clearData(ndx)
+		} else {
+			table.setData(ndx)
+		}
+		set[ndx] = !set[ndx]
+
+		// every slot's offset agrees with a plain count of the bits below it
+		dataMap, nodeMap, n := table.bitmaps()
+		c.Assert(n, Equals, bitmapWordCount(w))
+		c.Assert(bitmapCount(nodeMap[:n]), Equals, 0)
+		count := 0
+		for j := 0; j < slotCount; j++ {
+			offset, ok := table.dataSlot(uint64(j))
+			c.Assert(ok, Equals, set[j])
+			c.Assert(ok, Equals, bitmapTest(dataMap[:n], uint64(j)))
+			c.Assert(int(offset), Equals, count)
+			c.Assert(bitmapRank(dataMap[:n], uint64(j)), Equals, count)
+			_, ok = table.nodeSlot(uint64(j))
+			c.Assert(ok, Equals, false)
+			if set[j] {
+				count++
+			}
+		}
+		c.Assert(bitmapCount(dataMap[:n]), Equals, count)
+	}
+}
@@ -43,3 +43,19 @@ func (b BytesKey) Hashcode() (hc uint64) {
 
 	return
 }
+
+// Serialization ////////////////////////////////////////////////////
+
+// Encode the key as a copy of its bytes.
+func (b BytesKey) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), b.Slice...), nil
+}
+
+func (b *BytesKey) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 8 {
+		err = ShortKey
+	} else {
+		b.Slice = append([]byte(nil), data...)
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package hamt_go
+
+// hamt_go/capacity.go
+
+import (
+	"math"
+)
+
+// How NewHAMTWithCapacity trades memory against speed in choosing t.
+type Preference int
+
+const (
+	Balanced     Preference = iota // about 115 bytes/entry, t near log2(n)
+	PreferMemory                   // fewest bytes/entry, about 80
+	PreferSpeed                    // shallowest tries, about 135 bytes/entry
+)
+
+// Options for NewHAMTWithCapacity; nil means the zero value.
+type CapacityOptions struct {
+	Prefer Preference
+}
+
+// Bounds on the t chosen for a capacity: a root of 16 slots costs
+// little however small the map, and one of 2^32 slots is as large as
+// any map is likely to need.
+const (
+	minCapacityT = 4
+	maxCapacityT = 32
+)
+
+// Create a new HAMT expected to hold about n entries, choosing w and t
+// for it as chooseWT does.
+func NewHAMTWithCapacity(n uint, opts *CapacityOptions) (h HAMT, err error) {
+	var prefer Preference
+	if opts != nil {
+		prefer = opts.Prefer
+	}
+	w, t, err := chooseWT(n, prefer)
+	if err == nil {
+		h, err = NewHAMT(w, t)
+	}
+	return
+}
+
+// Choose w and t for a HAMT expected to hold about n entries.  The
+// sweep of t and w over 1000 to 2^20 BytesKeys in README.perf
+// (2026-10-19) shows that
+//   - at its best t, w of 6 uses as little memory as w of 7 and less
+//     than 5, and the earlier sweep of w found wider tables no faster
+//   - memory per entry varies with t in a cycle of length w, least,
+//     at 78 to 80 bytes/entry, where the deepest tables are about a
+//     quarter full: where t is round(log2(n)) - 4
+//   - finds get faster as t grows towards and past log2(n), the trie
+//     getting shallower, by some 15% at round(log2(n)) + 2, while the
+//     root's slots then cost about 64 bytes/entry more
+//
+// PreferMemory takes the first t, PreferSpeed the last, and Balanced
+// a root with about one slot per entry.  Any other Preference returns
+// UnknownPreference.
+func chooseWT(n uint, prefer Preference) (w, t uint, err error) {
+	log2n := 0
+	if n > 1 {
+		log2n = int(math.Round(math.Log2(float64(n))))
+	}
+	var tt int
+	switch prefer {
+	case Balanced:
+		tt = log2n
+	case PreferMemory:
+		tt = log2n - 4
+	case PreferSpeed:
+		tt = log2n + 2
+	default:
+		err = UnknownPreference
+	}
+	if err == nil {
+		if tt < minCapacityT {
+			tt = minCapacityT
+		} else if tt > maxCapacityT {
+			tt = maxCapacityT
+		}
+		w, t = 6, uint(tt)
+	}
+	return
+}
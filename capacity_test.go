@@ -0,0 +1,72 @@
+package hamt_go
+
+// hamt_go/capacity_test.go
+
+import (
+	"fmt"
+	. "gopkg.in/check.v1"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestChooseWT(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_CHOOSE_WT")
+	}
+	// the minima of memory per entry found in README.perf, and the
+	// choices either side
+	cases := []struct {
+		n                       uint
+		memory, balanced, speed uint
+	}{
+		{0, 4, 4, 4},
+		{100, 4, 7, 9},
+		{1000, 6, 10, 12},
+		{10000, 9, 13, 15},
+		{100000, 13, 17, 19},
+		{1 << 20, 16, 20, 22},
+		{1 << 40, 32, 32, 32},
+	}
+	for _, tc := range cases {
+		for i, prefer := range []Preference{PreferMemory, Balanced, PreferSpeed} {
+			expected := []uint{tc.memory, tc.balanced, tc.speed}[i]
+			w, t, err := chooseWT(tc.n, prefer)
+			c.Assert(err, IsNil)
+			c.Assert(w, Equals, uint(6))
+			c.Assert(t, Equals, expected)
+		}
+	}
+	_, _, err := chooseWT(1000, PreferSpeed+1)
+	c.Assert(err, Equals, UnknownPreference)
+}
+
+func (s *XLSuite) TestHAMTWithCapacity(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HAMT_WITH_CAPACITY")
+	}
+	const KEY_COUNT = 10000
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	_, err := NewHAMTWithCapacity(KEY_COUNT, &CapacityOptions{Prefer: -1})
+	c.Assert(err, Equals, UnknownPreference)
+	h, err := NewHAMTWithCapacity(KEY_COUNT, nil)
+	c.Assert(err, IsNil)
+	c.Assert(h.GetW(), Equals, uint(6))
+	c.Assert(h.GetT(), Equals, uint(13))
+
+	// preferring memory makes for fewer bytes and deeper tries
+	var stats []*Stats
+	for _, prefer := range []Preference{PreferMemory, Balanced, PreferSpeed} {
+		h, err := NewHAMTWithCapacity(KEY_COUNT, &CapacityOptions{Prefer: prefer})
+		c.Assert(err, IsNil)
+		for i := 0; i < KEY_COUNT; i++ {
+			c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+		}
+		c.Assert(h.Validate(), HasLen, 0)
+		stats = append(stats, h.Stats())
+	}
+	for i := 1; i < len(stats); i++ {
+		c.Assert(stats[i-1].Bytes < stats[i].Bytes, Equals, true)
+		c.Assert(stats[i-1].AvgDepth > stats[i].AvgDepth, Equals, true)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	xr "github.com/jddixon/rnglib_go"
 	"os"
 	"runtime/pprof"
+	"time"
 )
 
 var _ = errors.New
@@ -41,6 +42,8 @@ var (
 	testing       = flag.Bool("T", false, "test run")
 	usingSHA1     = flag.Bool("1", false, "test run")
 	verbose       = flag.Bool("v", false, "be talkative")
+	w             = flag.Uint("w", 6, "log2 of the size of lower-level tables")
+	sweepW        = flag.Bool("W", false, "compare each w from 4 to MAX_W")
 )
 
 // -- utilities -----------------------------------------------------
@@ -87,12 +90,23 @@ func doBenchmark(w, t uint, J, N uint, cpuProfFile *os.File) {
 	//fmt.Printf("setup time for %d %d-byte rawKeys: %v\n", N, K, deltaT)
 
 	pprof.StartCPUProfile(cpuProfFile)
+	findKeys(insertKeys(w, t, rawKeys, bKeys), bKeys, J)
+}
+
+// Insert each of the keys into a new HAMT, returning the HAMT.
+func insertKeys(w, t uint, rawKeys [][]byte, bKeys []gh.BytesKey) gh.HAMT {
 	// XXX we ignore any possible errors
 	m, _ := gh.NewHAMT(w, t)
 
-	for i := uint(0); i < N; i++ {
+	for i := 0; i < len(bKeys); i++ {
 		_ = m.Insert(bKeys[i], &rawKeys[i])
 	}
+	return m
+}
+
+// Find each of the keys in the HAMT J times.
+func findKeys(m gh.HAMT, bKeys []gh.BytesKey, J uint) {
+	N := uint(len(bKeys))
 
 	// verify several times that the rawKeys are present in the map
 	for j := uint(0); j < J; j++ {
@@ -113,6 +127,27 @@ func doBenchmark(w, t uint, J, N uint, cpuProfFile *os.File) {
 	}
 }
 
+// Run the benchmark for each w from 4 to MAX_W over the same keys,
+// reporting the time per insert and per find together with the shape
+// of the resulting trie, to show where wider tables pay off.
+func doSweep(t uint, J, N uint) {
+	rawKeys, bKeys := makeSomeUniqueKeys(N, 16)
+	fmt.Printf("%d keys, t = %d, %d finds per key\n", N, t, J)
+	fmt.Println(" w  insert ns   find ns   tables  avg depth  bytes/entry")
+	for w := uint(4); w <= gh.MAX_W; w++ {
+		t0 := time.Now()
+		m := insertKeys(w, t, rawKeys, bKeys)
+		t1 := time.Now()
+		findKeys(m, bKeys, J)
+		t2 := time.Now()
+		st := m.Stats()
+		fmt.Printf("%2d %10.1f %9.1f %8d %10.3f %12.1f\n", w,
+			float64(t1.Sub(t0).Nanoseconds())/float64(N),
+			float64(t2.Sub(t1).Nanoseconds())/float64(N*J),
+			st.TableCount, st.AvgDepth, st.BytesPerEntry)
+	}
+}
+
 // MAIN /////////////////////////////////////////////////////////////
 func main() {
 	var (
@@ -130,7 +165,8 @@ func main() {
 	if *testing {
 	}
 	// SANITY CHECKS ////////////////////////////////////////////////
-	if err == nil {
+	if err == nil && *w > gh.MAX_W {
+		err = gh.MaxTableSizeExceeded
 	}
 	// DISPLAY OPTIONS //////////////////////////////////////////////
 	if err == nil && *verbose || *justShow {
@@ -142,9 +178,14 @@ func main() {
 		fmt.Printf("testing     	= %v\n", *testing)
 		fmt.Printf("usingSHA1       = %v\n", *usingSHA1)
 		fmt.Printf("verbose     	= %v\n", *verbose)
+		fmt.Printf("w           	= %v\n", *w)
+		fmt.Printf("sweepW      	= %v\n", *sweepW)
 	}
 	// DO IT ////////////////////////////////////////////////////////
-	if err == nil && !*justShow {
+	if err == nil && !*justShow && *sweepW {
+		n := uint(19)
+		doSweep(n-2, 16, 2<<n)
+	} else if err == nil && !*justShow {
 		cpuProfFile, err = os.Create(*cpuProf)
 		if err == nil {
 			defer cpuProfFile.Close()
@@ -158,7 +199,7 @@ func main() {
 		j := uint(16) // number of finds per insert
 		if err == nil {
 			//         w , t , J,  N
-			doBenchmark(6, t, j, 2<<n, cpuProfFile)
+			doBenchmark(*w, t, j, 2<<n, cpuProfFile)
 		}
 		if err == nil {
 			if memProfFile != nil {
@@ -17,7 +17,9 @@ import (
 	gh "github.com/jddixon/hamt_go"
 	xr "github.com/jddixon/rnglib_go"
 	"os"
+	"runtime"
 	"runtime/pprof"
+	"time"
 )
 
 var _ = errors.New
@@ -36,6 +38,9 @@ var (
 	memProf = flag.String("m", "", "memprofile file name")
 
 	justShow      = flag.Bool("j", false, "display option settings and exit")
+	showGC        = flag.Bool("G", false, "show garbage collector statistics")
+	useArena      = flag.Bool("A", false, "use an ArenaHAMT")
+	showStats     = flag.Bool("S", false, "show structural statistics")
 	showTimestamp = flag.Bool("t", false, "output UTC timestamp")
 	showVersion   = flag.Bool("V", false, "output package version info")
 	testing       = flag.Bool("T", false, "test run")
@@ -85,7 +90,15 @@ func doBenchmark(w, t uint, N int, cpuProfFile *os.File) {
 
 	pprof.StartCPUProfile(cpuProfFile)
 	// XXX we ignore any possible errors
-	m, _ := gh.NewHAMT(w, t)
+	var m interface {
+		Insert(gh.KeyI, interface{}) error
+		Find(gh.KeyI) (interface{}, error)
+	}
+	if *useArena {
+		m, _ = gh.NewArenaHAMT(w, t)
+	} else {
+		m, _ = gh.NewHAMT(w, t)
+	}
 
 	for i := 0; i < N; i++ {
 		_ = m.Insert(bKeys[i], &rawKeys[i])
@@ -106,6 +119,23 @@ func doBenchmark(w, t uint, N int, cpuProfFile *os.File) {
 		_ = value
 
 	} // GEEP
+	if *showStats {
+		if h, ok := m.(gh.HAMT); ok {
+			fmt.Print(h.Stats().String())
+		}
+	}
+	if *showGC {
+		var ms runtime.MemStats
+		t0 := time.Now()
+		runtime.GC()
+		deltaT := time.Since(t0)
+		runtime.ReadMemStats(&ms)
+		fmt.Printf("full GC took %v; %d heap objects, %.2f MB in use\n",
+			deltaT, ms.HeapObjects, float64(ms.HeapAlloc)/(1000*1000))
+		fmt.Printf("%d GCs, %v total pause\n",
+			ms.NumGC, time.Duration(ms.PauseTotalNs))
+	}
+	runtime.KeepAlive(m)
 }
 
 // MAIN /////////////////////////////////////////////////////////////
@@ -132,9 +162,12 @@ func main() {
 		fmt.Printf("cpuProf    	= %v\n", *cpuProf)
 		fmt.Printf("memProf    	= %v\n", *memProf)
 		fmt.Printf("justShow    	= %v\n", *justShow)
+		fmt.Printf("showGC      	= %v\n", *showGC)
+		fmt.Printf("showStats   	= %v\n", *showStats)
 		fmt.Printf("showTimestamp   = %v\n", *showTimestamp)
 		fmt.Printf("showVersion 	= %v\n", *showVersion)
 		fmt.Printf("testing     	= %v\n", *testing)
+		fmt.Printf("useArena    	= %v\n", *useArena)
 		fmt.Printf("usingSHA1       = %v\n", *usingSHA1)
 		fmt.Printf("verbose     	= %v\n", *verbose)
 	}
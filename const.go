@@ -1,5 +1,6 @@
 package hamt_go
 
 const (
-	MAX_W = uint(6)
+	MAX_W   = uint(8)
+	MAX_W32 = uint(5) // in a HAMT32
 )
@@ -0,0 +1,9 @@
+//go:build !hamt_debug
+// +build !hamt_debug
+
+package hamt_go
+
+// hamt_go/debugOff.go
+
+// See debugOn.go.
+const debugValidate = false
@@ -0,0 +1,11 @@
+//go:build hamt_debug
+// +build hamt_debug
+
+package hamt_go
+
+// hamt_go/debugOn.go
+
+// Built with -tags hamt_debug: every Insert and Delete validates the
+// entire trie, panicking if any invariant is violated.  This is very
+// slow and is meant for tests and debugging only.
+const debugValidate = true
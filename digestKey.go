@@ -0,0 +1,70 @@
+package hamt_go
+
+// hamt_go/digestKey.go
+
+// A DigestKey is a cryptographic digest, such as a SHA-1 or SHA-256
+// hash, used as a key.  The bytes of such a digest are already
+// uniformly distributed, so, as for a BytesKey, the hashcode is simply
+// the first 8 bytes; but a DigestKey has a fixed length, holds its own
+// copy of the digest, and is compared with ==.
+type DigestKey struct {
+	digest string
+}
+
+// Return whether n is the length in bytes of a common digest: MD5,
+// SHA-1, SHA-224, SHA-256, SHA-384, or SHA-512.
+func isDigestLen(n int) bool {
+	switch n {
+	case 16, 20, 28, 32, 48, 64:
+		return true
+	}
+	return false
+}
+
+// Create a DigestKey holding a copy of the digest d.
+func NewDigestKey(d []byte) (k DigestKey, err error) {
+	if d == nil {
+		err = NilKey
+	} else if !isDigestLen(len(d)) {
+		err = BadDigestLength
+	} else {
+		k = DigestKey{digest: string(d)}
+	}
+	return
+}
+
+// Return a copy of the digest.
+func (k DigestKey) Bytes() []byte {
+	return []byte(k.digest)
+}
+
+// KeyI interface ///////////////////////////////////////////////////
+
+// Convert the first 8 bytes of the digest into a uint64.
+func (k DigestKey) Hashcode() uint64 {
+	s := k.digest
+	return uint64(s[0]) |
+		uint64(s[1])<<8 |
+		uint64(s[2])<<16 |
+		uint64(s[3])<<24 |
+		uint64(s[4])<<32 |
+		uint64(s[5])<<40 |
+		uint64(s[6])<<48 |
+		uint64(s[7])<<56
+}
+
+// Serialization ////////////////////////////////////////////////////
+
+// Encode the key as the bytes of the digest.
+func (k DigestKey) MarshalBinary() ([]byte, error) {
+	return k.Bytes(), nil
+}
+
+func (k *DigestKey) UnmarshalBinary(data []byte) (err error) {
+	if !isDigestLen(len(data)) {
+		err = BadDigestLength
+	} else {
+		k.digest = string(data)
+	}
+	return
+}
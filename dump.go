@@ -0,0 +1,266 @@
+package hamt_go
+
+// hamt_go/dump.go
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Options controlling how much of a HAMT WriteDOT and WriteText render,
+// so that huge maps remain readable.  The zero value renders everything.
+type DumpOptions struct {
+	// Render Tables no deeper than this; 0 means no limit.
+	MaxDepth uint
+	// Render only every n-th occupied Root slot; 0 or 1 means all.
+	RootSample uint
+	// Render at most this many occupied slots in the Root and in each
+	// Table; 0 means no limit.
+	MaxSlots uint
+}
+
+// Walks a trie writing it out either in Graphviz DOT format or as an
+// indented text tree.  The first write error is sticky.
+type dumper struct {
+	root   *Root
+	root32 *root32 // in place of root for a HAMT32
+	out    io.Writer
+	opts   DumpOptions
+	dot    bool
+	nextID uint
+	err    error
+}
+
+func (d *dumper) printf(format string, args ...interface{}) {
+	if d.err == nil {
+		_, d.err = fmt.Fprintf(d.out, format, args...)
+	}
+}
+
+// Return a new DOT node identifier.
+func (d *dumper) newID(prefix string) string {
+	d.nextID++
+	return fmt.Sprintf("%s%d", prefix, d.nextID)
+}
+
+func (d *dumper) leafLabel(leaf *Leaf) string {
+	if d.root32 != nil {
+		return fmt.Sprintf("leaf %08x", hash32(leaf.Key))
+	}
+	return fmt.Sprintf("leaf %016x", d.root.hash(leaf.Key))
+}
+
+func tableLabel(table *Table, depth uint) string {
+	if table.isPage() {
+		return fmt.Sprintf("page depth %d (%d entries)", depth, table.usedSlots())
+	}
+	bitmap := table.bitmap()
+	var b strings.Builder
+	for i := len(bitmap) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%016x", bitmap[i]) // highest slots first
+	}
+	label := fmt.Sprintf("table depth %d bitmap %s (%d of %d slots)",
+		depth, b.String(), table.usedSlots(), table.MaxSlots())
+	if table.skip != 0 {
+		label += fmt.Sprintf(", skipping %d levels, prefix %x",
+			table.skip, table.prefix)
+	}
+	return label
+}
+
+func table32Label(root *root32, table *table32, depth uint) string {
+	used := len(table.values) + len(table.nodes)
+	if depth > root.maxTableDepth {
+		return fmt.Sprintf("bucket depth %d (%d entries)", depth, used)
+	}
+	return fmt.Sprintf("table depth %d bitmap %08x (%d of %d slots)",
+		depth, table.dataMap|table.nodeMap, used, 1<<root.widths[depth])
+}
+
+// Note, as an elided node, that count occupied slots are not shown.
+func (d *dumper) elided(parentID string, indent string, count uint) {
+	if count > 0 {
+		if d.dot {
+			id := d.newID("x")
+			d.printf("  %s [shape=plaintext,label=\"... %d more\"];\n", id, count)
+			d.printf("  %s -> %s [style=dotted];\n", parentID, id)
+		} else {
+			d.printf("%s... %d more\n", indent, count)
+		}
+	}
+}
+
+// Render a root, labelled with its widths, t, and number of slots,
+// whose occupied slots nextSlot visits in order as Root.nextSlot does.
+func (d *dumper) dumpRoot(widths string, t, slotCount uint,
+	nextSlot func(uint) (uint, HTNodeI)) {
+
+	used := uint(0)
+	for i, node := nextSlot(0); node != nil; i, node = nextSlot(i + 1) {
+		used++
+	}
+	label := fmt.Sprintf("root w %s t %d (%d of %d slots)",
+		widths, t, used, slotCount)
+	if d.dot {
+		d.printf("digraph HAMT {\n")
+		d.printf("  node [fontname=\"monospace\",fontsize=10];\n")
+		d.printf("  root [shape=box,style=bold,label=\"%s\"];\n", label)
+	} else {
+		d.printf("%s\n", label)
+	}
+	sample := d.opts.RootSample
+	if sample == 0 {
+		sample = 1
+	}
+	var seen, shown uint
+	for i, node := nextSlot(0); node != nil && d.err == nil; i, node = nextSlot(i + 1) {
+		seen++
+		if (seen-1)%sample != 0 {
+			continue
+		}
+		if d.opts.MaxSlots > 0 && shown >= d.opts.MaxSlots {
+			continue
+		}
+		shown++
+		d.dumpNode("root", "  ", i, node, 0)
+	}
+	d.elided("root", "  ", used-shown)
+	if d.dot {
+		d.printf("}\n")
+	}
+}
+
+// Render a node found in slot ndx of a table at depth, and anything
+// below it.
+func (d *dumper) dumpNode(parentID string, indent string, ndx uint,
+	node HTNodeI, depth uint) {
+
+	if node.IsLeaf() {
+		leaf := node.(*Leaf)
+		if d.dot {
+			id := d.newID("l")
+			d.printf("  %s [shape=ellipse,label=\"%s\"];\n", id, d.leafLabel(leaf))
+			d.printf("  %s -> %s [label=\"%d\"];\n", parentID, id, ndx)
+		} else {
+			d.printf("%s[%d] %s\n", indent, ndx, d.leafLabel(leaf))
+		}
+		return
+	}
+	if table, ok := node.(*table32); ok {
+		d.dumpTable32(parentID, indent, ndx, table, depth+1)
+		return
+	}
+	table := node.(*Table)
+	depth += 1 + uint(table.skip)
+	id := d.tableNode(parentID, indent, ndx, tableLabel(table, depth))
+	childIndent := indent + "  "
+	if d.opts.MaxDepth > 0 && depth >= d.opts.MaxDepth {
+		d.elided(id, childIndent, table.usedSlots())
+		return
+	}
+	shown := uint(0)
+	if table.isPage() {
+		// entries are numbered by their position in the page
+		for i := 0; i < len(table.values) && d.err == nil; i++ {
+			if d.opts.MaxSlots > 0 && shown >= d.opts.MaxSlots {
+				break
+			}
+			shown++
+			d.dumpNode(id, childIndent, uint(i), table.leafAt(i), depth)
+		}
+		d.elided(id, childIndent, table.usedSlots()-shown)
+		return
+	}
+	for n := uint64(0); n <= uint64(table.mask) && d.err == nil; n++ {
+		child := table.childAt(n)
+		if child == nil {
+			continue
+		}
+		if d.opts.MaxSlots > 0 && shown >= d.opts.MaxSlots {
+			break
+		}
+		shown++
+		d.dumpNode(id, childIndent, uint(n), child, depth)
+	}
+	d.elided(id, childIndent, table.usedSlots()-shown)
+}
+
+// Render a table found in slot ndx of a table at depth-1, returning its
+// node identifier.
+func (d *dumper) tableNode(parentID string, indent string, ndx uint,
+	label string) (id string) {
+
+	id = d.newID("t")
+	if d.dot {
+		d.printf("  %s [shape=box,label=\"%s\"];\n", id, label)
+		d.printf("  %s -> %s [label=\"%d\"];\n", parentID, id, ndx)
+	} else {
+		d.printf("%s[%d] %s\n", indent, ndx, label)
+	}
+	return
+}
+
+// Render a HAMT32's table found in slot ndx of a table at depth-1, and
+// anything below it.  The entries of a collision bucket are numbered
+// by their position in it.
+func (d *dumper) dumpTable32(parentID string, indent string, ndx uint,
+	table *table32, depth uint) {
+
+	root := d.root32
+	id := d.tableNode(parentID, indent, ndx, table32Label(root, table, depth))
+	childIndent := indent + "  "
+	used := uint(len(table.values) + len(table.nodes))
+	if d.opts.MaxDepth > 0 && depth >= d.opts.MaxDepth {
+		d.elided(id, childIndent, used)
+		return
+	}
+	shown := uint(0)
+	var dataNbr, nodeNbr int
+	for n := uint(0); d.err == nil; n++ {
+		var child HTNodeI
+		if depth > root.maxTableDepth {
+			if n >= uint(len(table.values)) {
+				break
+			}
+			child = &Leaf{Key: table.keys[n], Value: table.values[n]}
+		} else if n >= uint(1)<<root.widths[depth] {
+			break
+		} else if flag := uint32(1) << n; table.dataMap&flag != 0 {
+			child = &Leaf{Key: table.keys[dataNbr], Value: table.values[dataNbr]}
+			dataNbr++
+		} else if table.nodeMap&flag != 0 {
+			child = table.nodes[nodeNbr]
+			nodeNbr++
+		} else {
+			continue
+		}
+		if d.opts.MaxSlots > 0 && shown >= d.opts.MaxSlots {
+			break
+		}
+		shown++
+		d.dumpNode(id, childIndent, n, child, depth)
+	}
+	d.elided(id, childIndent, used-shown)
+}
+
+// Write the trie either in Graphviz DOT format or as indented text.
+func (root *Root) dump(out io.Writer, opts *DumpOptions, dot bool) error {
+	d := &dumper{root: root, out: out, dot: dot}
+	if opts != nil {
+		d.opts = *opts
+	}
+	d.dumpRoot(formatWidths(root.schedule), root.t, root.slotCount,
+		root.nextSlot)
+	return d.err
+}
+
+// Write a HAMT32's trie as dump does a HAMT's.
+func (root *root32) dump(out io.Writer, opts *DumpOptions, dot bool) error {
+	d := &dumper{root32: root, out: out, dot: dot}
+	if opts != nil {
+		d.opts = *opts
+	}
+	d.dumpRoot(fmt.Sprint(root.w), root.t, root.slotCount, root.nextSlot)
+	return d.err
+}
@@ -0,0 +1,107 @@
+package hamt_go
+
+// hamt_go/dump_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestDump(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_DUMP")
+	}
+	const KEY_COUNT = 1024
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+	h, err := NewHAMT(4, 6)
+	c.Assert(err, IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		err = h.Insert(bKeys[i], &rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	st := h.Stats()
+	nodeCount := int(st.LeafCount + st.TableCount)
+
+	// with no limits, every node is shown: one line per node in text,
+	// one edge per node but the root in DOT
+	var buf bytes.Buffer
+	err = h.WriteText(&buf, nil)
+	c.Assert(err, IsNil)
+	text := buf.String()
+	c.Assert(strings.HasPrefix(text, "root w 4 t 6"), Equals, true)
+	c.Assert(strings.Count(text, "\n"), Equals, nodeCount)
+	c.Assert(strings.Count(text, "] leaf "), Equals, int(st.LeafCount))
+	c.Assert(strings.Contains(text, "more"), Equals, false)
+
+	buf.Reset()
+	err = h.WriteDOT(&buf, nil)
+	c.Assert(err, IsNil)
+	dot := buf.String()
+	c.Assert(strings.HasPrefix(dot, "digraph HAMT {\n"), Equals, true)
+	c.Assert(strings.HasSuffix(dot, "}\n"), Equals, true)
+	c.Assert(strings.Count(dot, " -> "), Equals, nodeCount-1)
+
+	// a depth limit hides everything below that depth
+	buf.Reset()
+	err = h.WriteText(&buf, &DumpOptions{MaxDepth: 1})
+	c.Assert(err, IsNil)
+	text = buf.String()
+	c.Assert(strings.Contains(text, "depth 1"), Equals, true)
+	c.Assert(strings.Contains(text, "depth 2"), Equals, false)
+	c.Assert(strings.Contains(text, "more"), Equals, true)
+
+	// sampling the root and limiting slots shrink the output
+	buf.Reset()
+	err = h.WriteDOT(&buf, &DumpOptions{RootSample: 4, MaxSlots: 2})
+	c.Assert(err, IsNil)
+	sampled := buf.String()
+	c.Assert(len(sampled) < len(dot)/2, Equals, true)
+	c.Assert(strings.Contains(sampled, "style=dotted"), Equals, true)
+}
+
+func (s *XLSuite) TestDumpOfLongPaths(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_DUMP_OF_LONG_PATHS")
+	}
+	rng := xr.MakeSimpleRNG()
+	w := uint(5)
+	_, rawKeys := s.makePermutedKeys(rng, w)
+	KEY_COUNT := 64 / w
+
+	h, err := NewHAMT(w, w)
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	err = h.WriteText(&buf, nil)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "root w 5 t 5 (0 of 32 slots)\n")
+
+	for i := uint(0); i < KEY_COUNT; i++ {
+		bKey, err := NewBytesKey(rawKeys[i])
+		c.Assert(err, IsNil)
+		err = h.Insert(bKey, &rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	buf.Reset()
+	err = h.WriteText(&buf, nil)
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	c.Assert(uint(len(lines)), Equals, 2*KEY_COUNT)
+
+	// each table is indented one step more than its parent
+	for d := uint(1); d < KEY_COUNT; d++ {
+		prefix := strings.Repeat("  ", int(d)) + "["
+		found := false
+		for i := 0; i < len(lines); i++ {
+			if strings.HasPrefix(lines[i], prefix) &&
+				strings.Contains(lines[i], fmt.Sprintf("table depth %d ", d)) {
+				found = true
+			}
+		}
+		c.Assert(found, Equals, true)
+	}
+}
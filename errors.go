@@ -5,14 +5,42 @@ import (
 )
 
 var (
+	ArenaFull                = e.New("arena has no room for more tables or entries")
+	BadDigestLength          = e.New("not the length of a supported digest")
+	BadKeyEncoding           = e.New("malformed key encoding")
+	BadSyncMessage           = e.New("malformed sync message")
 	DeleteFromEmptyTable     = e.New("Internal Error: delete from empty table")
+	HasherUnsupported        = e.New("HAMT with a hasher not supported")
+	IncompatibleOptions      = e.New("options cannot be used together")
+	InvalidProof             = e.New("proof does not match digest")
+	MaxEntriesExceeded       = e.New("max entries exceeded")
+	MaxFrozenRootExceeded    = e.New("max frozen sparse Root size (t=24) exceeded")
 	MaxTableDepthExceeded    = e.New("max Table depth exceeded")
-	MaxTableSizeExceeded     = e.New("max Table size (w=6) exceeded")
+	MaxTableSizeExceeded     = e.New("max Table size (w=8) exceeded")
+	MaxPageSizeExceeded      = e.New("max leaf page size (k=64) exceeded")
 	MaxRootTableSizeExceeded = e.New("max Root table size (t=64) exceeded")
+	MaxSparseRootExceeded    = e.New("max sparse Root size (t=63) exceeded")
+	MaxRoot32SizeExceeded    = e.New("max HAMT32 Root table size (t=32) exceeded")
+	MaxTable32SizeExceeded   = e.New("max HAMT32 Table size (w=5) exceeded")
+	MismatchedReplicas       = e.New("replicas have different table sizes")
+	NilHasher                = e.New("nil hasher parameter")
 	NilKey                   = e.New("nil key parameter")
+	NilMetricsHook           = e.New("nil metrics hook parameter")
 	NilRoot                  = e.New("nil root parameter")
 	NilValue                 = e.New("nil value parameter")
+	NotMerkleHAMT            = e.New("HAMT does not maintain digests")
+	PagesUnsupported         = e.New("HAMT with leaf pages not supported")
+	PathsUnsupported         = e.New("HAMT with compressed paths not supported")
 	NotFound                 = e.New("entry not found")
+	ReadOnlyHAMT             = e.New("frozen HAMT cannot be modified")
 	ShortKey                 = e.New("Bytes*Key is too short")
+	SyncIncomplete           = e.New("replica changed during sync")
+	UnknownCollisionPolicy   = e.New("unknown collision policy")
+	UnknownConcurrencyMode   = e.New("unknown concurrency mode")
+	UnknownKeyOwnership      = e.New("unknown key ownership policy")
+	UnknownPreference        = e.New("unknown memory/speed preference")
+	UnsupportedKeyType       = e.New("key type not supported")
+	UnsupportedValueType     = e.New("value type cannot be digested")
+	WideTablesUnsupported    = e.New("tables of more than 64 slots (w>6) not supported")
 	ZeroLengthTables         = e.New("Cannot create: zero length tables")
 )
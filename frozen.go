@@ -0,0 +1,179 @@
+package hamt_go
+
+// hamt_go/frozen.go
+
+import (
+	"math/bits"
+)
+
+// A FrozenHAMT is a read-only copy of a HAMT, compacted for lookups.
+// Its tables are laid out contiguously in one slice in breadth-first
+// order, so that the subtables of any table are adjacent, and its keys
+// and values are held in a single pair of exactly sized slices, the
+// entries of each table adjacent and in slot order.  A table then
+// needs only its two bitmaps and the indices of its first entry and
+// first subtable; the position of anything below it is found by
+// popcount alone, with no interface dispatch and no pointer chasing.
+//
+// A FrozenHAMT holds no digests, and its tables may have no more than
+// 64 slots.  Insert and Delete return ReadOnlyHAMT.
+//
+// Its root is dense, a ref for each of the 2^t slots.  That costs a
+// quarter of what a dense Root does, but a HAMT with a sparse Root was
+// made sparse to avoid just such an allocation, so one with t greater
+// than maxFrozenSparseT cannot be frozen.
+type FrozenHAMT struct {
+	w, t      uint
+	rootMask  uint64
+	widths    []uint   // by depth, as in the Root
+	rootSlots []uint32 // 2^t refs, as in an ArenaHAMT
+	tables    []frozenTable
+	keys      []KeyI
+	values    []interface{}
+}
+
+// The largest t of a sparse Root which may be frozen: its 2^24 refs
+// take 64 MB.
+const maxFrozenSparseT = 24
+
+// A table in a FrozenHAMT.  Entries are numbered from data in the
+// FrozenHAMT's keys and values, subtables from nodes in its tables.
+type frozenTable struct {
+	dataMap uint64
+	nodeMap uint64
+	data    uint32
+	nodes   uint32
+}
+
+// Return a FrozenHAMT holding the same keys and values as this HAMT.
+// The HAMT itself is unchanged and may continue to be used.
+func (h HAMT) Freeze() (f *FrozenHAMT, err error) {
+	root := h.root
+	root.readLock()
+	defer root.readUnlock()
+	leafCount, tableCount := root.getLeafCount(), root.getTableCount()-1
+	if maxWidth(root.schedule) > maxWordW {
+		err = WideTablesUnsupported
+		return
+	} else if root.pageSize > 1 || root.buckets {
+		err = PagesUnsupported
+		return
+	} else if root.compressPaths {
+		err = PathsUnsupported
+		return
+	} else if root.hasher != nil {
+		err = HasherUnsupported
+		return
+	} else if root.groups != nil && root.t > maxFrozenSparseT {
+		err = MaxFrozenRootExceeded
+		return
+	} else if leafCount >= uint(maxArenaRefs) || tableCount >= uint(maxArenaRefs) {
+		err = ArenaFull
+		return
+	}
+	f = &FrozenHAMT{
+		w:         root.w,
+		t:         root.t,
+		rootMask:  root.mask,
+		widths:    root.widths,
+		rootSlots: make([]uint32, root.slotCount),
+		tables:    make([]frozenTable, 0, tableCount),
+		keys:      make([]KeyI, 0, leafCount),
+		values:    make([]interface{}, 0, leafCount),
+	}
+	// queue holds the tables in breadth-first order; the table at
+	// queue[i] becomes f.tables[i]
+	queue := make([]*Table, 0, tableCount)
+	for i, node := root.nextSlot(0); node != nil; i, node = root.nextSlot(i + 1) {
+		switch node := node.(type) {
+		case *Leaf:
+			f.keys = append(f.keys, node.Key)
+			f.values = append(f.values, node.Value)
+			f.rootSlots[i] = uint32(len(f.keys))
+		case *Table:
+			f.rootSlots[i] = arenaTableBit | uint32(len(queue))
+			queue = append(queue, node)
+		}
+	}
+	for i := 0; i < len(queue); i++ {
+		table := queue[i]
+		f.tables = append(f.tables, frozenTable{
+			dataMap: table.dataMap,
+			nodeMap: table.nodeMap,
+			data:    uint32(len(f.keys)),
+			nodes:   uint32(len(queue)),
+		})
+		for j := 0; j < len(table.values); j++ {
+			f.keys = append(f.keys, table.keyAt(j))
+			f.values = append(f.values, table.values[j])
+		}
+		queue = append(queue, table.nodes...)
+	}
+	return
+}
+
+// Return t which determines the size of the root table (2^t).
+func (f *FrozenHAMT) GetT() uint {
+	return f.t
+}
+
+// Return w which determines the size of lower-level tables (2^w).
+func (f *FrozenHAMT) GetW() uint {
+	return f.w
+}
+
+// Return the number of entries in the HAMT.
+func (f *FrozenHAMT) GetLeafCount() uint {
+	return uint(len(f.keys))
+}
+
+// Return the number of tables in the HAMT, including the root.
+func (f *FrozenHAMT) GetTableCount() uint {
+	return uint(1 + len(f.tables))
+}
+
+// If there is an entry with the key k in the HAMT, return the value
+// associated with the key.  If there is no such entry, return nil.
+func (f *FrozenHAMT) Find(k KeyI) (value interface{}, err error) {
+	if k == nil {
+		err = NilKey
+		return
+	}
+	hc := k.Hashcode()
+	ref := f.rootSlots[hc&f.rootMask]
+	entry := ref - 1 // meaningful only if ref is neither nil nor a table
+	if ref&arenaTableBit != 0 {
+		hc >>= f.t
+		tbl := &f.tables[ref&^arenaTableBit]
+		for depth := 1; ; depth++ {
+			w := f.widths[depth]
+			flag := uint64(1) << (hc & (uint64(1)<<w - 1))
+			if tbl.dataMap&flag != 0 {
+				entry = tbl.data + uint32(bits.OnesCount64(tbl.dataMap&(flag-1)))
+				break
+			}
+			if tbl.nodeMap&flag == 0 {
+				return
+			}
+			// the trie was valid when frozen, so no depth check is needed
+			tbl = &f.tables[tbl.nodes+uint32(bits.OnesCount64(tbl.nodeMap&(flag-1)))]
+			hc >>= w
+		}
+	} else if ref == arenaNil {
+		return
+	}
+	if sameKey(f.keys[entry], k) {
+		value = f.values[entry]
+	}
+	return
+}
+
+// A FrozenHAMT cannot be modified; this returns ReadOnlyHAMT.
+func (f *FrozenHAMT) Insert(k KeyI, v interface{}) error {
+	return ReadOnlyHAMT
+}
+
+// A FrozenHAMT cannot be modified; this returns ReadOnlyHAMT.
+func (f *FrozenHAMT) Delete(k KeyI) error {
+	return ReadOnlyHAMT
+}
@@ -0,0 +1,92 @@
+package hamt_go
+
+// hamt_go/frozen_test.go
+
+import (
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestFrozenHAMT(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_FROZEN_HAMT")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestFrozenHAMT(c, rng, 4, 4)
+	s.doTestFrozenHAMT(c, rng, 5, 8)
+	s.doTestFrozenHAMT(c, rng, 6, 12)
+
+	// tables of more than 64 slots cannot be frozen
+	h, err := NewHAMT(maxWordW+1, 4)
+	c.Assert(err, IsNil)
+	_, err = h.Freeze()
+	c.Assert(err, Equals, WideTablesUnsupported)
+}
+
+func (s *XLSuite) doTestFrozenHAMT(c *C, rng *xr.PRNG, w, t uint) {
+	const KEY_COUNT = 4096
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	h, err := NewHAMT(w, t)
+	c.Assert(err, IsNil)
+	// an empty HAMT freezes
+	f, err := h.Freeze()
+	c.Assert(err, IsNil)
+	c.Assert(f.GetLeafCount(), Equals, uint(0))
+	c.Assert(f.GetTableCount(), Equals, uint(1))
+
+	// freeze only half the keys
+	for i := 0; i < KEY_COUNT/2; i++ {
+		c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+	}
+	f, err = h.Freeze()
+	c.Assert(err, IsNil)
+	c.Assert(f.GetW(), Equals, w)
+	c.Assert(f.GetT(), Equals, t)
+	c.Assert(f.GetLeafCount(), Equals, h.GetLeafCount())
+	c.Assert(f.GetTableCount(), Equals, h.GetTableCount())
+	c.Assert(len(f.keys), Equals, cap(f.keys))
+	c.Assert(len(f.tables), Equals, cap(f.tables))
+
+	// changing the HAMT afterwards leaves the frozen copy as it was
+	for i := KEY_COUNT / 2; i < KEY_COUNT; i++ {
+		c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+	}
+	c.Assert(h.Insert(bKeys[0], "new value"), IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := f.Find(bKeys[i])
+		c.Assert(err, IsNil)
+		if i < KEY_COUNT/2 {
+			c.Assert(value, Equals, &rawKeys[i])
+		} else {
+			c.Assert(value, IsNil)
+		}
+	}
+	_, err = f.Find(nil)
+	c.Assert(err, Equals, NilKey)
+
+	// and it cannot itself be changed
+	c.Assert(f.Insert(bKeys[0], "x"), Equals, ReadOnlyHAMT)
+	c.Assert(f.Delete(bKeys[1]), Equals, ReadOnlyHAMT)
+	value, err := f.Find(bKeys[1])
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, &rawKeys[1])
+
+	// keys of other types freeze too
+	h, err = NewHAMT(w, t)
+	c.Assert(err, IsNil)
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Insert(Uint64Key(perm[i]), i), IsNil)
+	}
+	f, err = h.Freeze()
+	c.Assert(err, IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := f.Find(Uint64Key(perm[i]))
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+}
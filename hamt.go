@@ -3,6 +3,7 @@ package hamt_go
 // hamt_go/hamt.go
 import (
 	"fmt"
+	"io"
 )
 
 var _ = fmt.Print
@@ -13,20 +14,76 @@ type HAMT struct {
 
 // Create a new HAMT with 2^t slots in its root table and 2^w slots in
 // all lower-level tables.  If t equals zero, it defaults to w.  If
-// both t and w are zero, it panics.  In lower-level tables, a uint64
-// is used as a bitmap, so w may not exceed 6 (because 2^6 == 64).
+// both t and w are zero, it returns ZeroLengthTables.  In lower-level
+// tables, a uint64 is used as a bitmap, so up to w=6 (because 2^6 ==
+// 64) a table's bitmaps take one word each; w of 7 or 8 uses
+// multi-word bitmaps, and w may not exceed 8.  This is New(WithW(w),
+// WithT(t)); see options.go for the other properties New can set.
 func NewHAMT(w, t uint) (h HAMT, err error) {
 	if t == 0 && w == 0 {
 		err = ZeroLengthTables
 	} else {
-		if w > MAX_W {
-			err = MaxTableSizeExceeded
-		} else {
+		h, err = New(WithW(w), WithT(t))
+	}
+	return
+}
+
+// Create a new HAMT whose lower-level tables differ in size by depth.
+// widths[0] is w for the tables at depth 1, immediately below the
+// root, widths[1] for those at depth 2, and so on; the last width
+// applies to all deeper tables.  So [6, 6, 5, 4] gives tables of 64
+// slots at depths 1 and 2, 32 at depth 3, and 16 below that: wide
+// tables near the root keep the trie shallow, while the sparsely
+// filled tables near the leaves stay small.  Each width may be from 1
+// to 8.  If t equals zero, it defaults to widths[0].
+func NewHAMTWithWidths(widths []uint, t uint) (h HAMT, err error) {
+	schedule, err := normalizeWidths(widths)
+	if err == nil {
+		if t == 0 {
+			t = schedule[0]
+		}
+		var root *Root
+		root, err = newRootWithWidths(schedule, t, false)
+		if err == nil {
+			h = HAMT{
+				root: root,
+			}
+		}
+	}
+	return
+}
+
+// Create a new Merkle HAMT, one which maintains a digest over all of
+// its keys and values.  The parameters are as for NewHAMT.  Keys must be
+// BytesKeys.  Values must be byte slices, pointers to byte slices,
+// strings, or implement encoding.BinaryMarshaler; see merkle.go.
+func NewMerkleHAMT(w, t uint) (h HAMT, err error) {
+	h, err = NewHAMT(w, t)
+	if err == nil {
+		h.root.initMerkle()
+	}
+	return
+}
+
+// Create a new HAMT whose root table is sparse, holding its 2^t slots
+// in groups of 64 compressed by bitmap, as lower-level tables are, and
+// reaching them through a directory of groups; see sparseRoot.go.  Its
+// memory then grows with the number of root slots in use rather than
+// with 2^t, at the cost of a popcount for each six bits of t on each
+// lookup, which suits a large t with few entries.  The parameters are
+// as for NewHAMT, except that t may be at most 63.
+func NewSparseHAMT(w, t uint) (h HAMT, err error) {
+	if t == 0 && w == 0 {
+		err = ZeroLengthTables
+	} else {
+		var schedule []uint
+		schedule, err = normalizeWidths([]uint{w})
+		if err == nil {
 			if t == 0 {
 				t = w
 			}
 			var root *Root
-			root, err = NewRoot(w, t)
+			root, err = newRootWithWidths(schedule, t, true)
 			if err == nil {
 				h = HAMT{
 					root: root,
@@ -37,44 +94,233 @@ func NewHAMT(w, t uint) (h HAMT, err error) {
 	return
 }
 
+// Create a new HAMT which keeps up to k entries below any slot together
+// in a leaf page, searched in turn, rather than building a Table for
+// each hashcode chunk they share; see pages.go.  This makes fewer and
+// shallower tables where keys cluster.  w and t are as for NewHAMT,
+// and k may be at most 64; if k is less than 2, there are no pages.
+func NewHAMTWithPages(w, t, k uint) (h HAMT, err error) {
+	if k > maxPageSize {
+		err = MaxPageSizeExceeded
+	} else {
+		h, err = NewHAMT(w, t)
+		if err == nil && k > 1 {
+			h.root.pageSize = k
+		}
+	}
+	return
+}
+
+// Create a new HAMT whose Tables may skip any levels at which the
+// hashcodes of all the keys below them agree, rather than building a
+// chain of Tables, each with a single slot in use; see paths.go.  w and
+// t are as for NewHAMT.
+func NewHAMTWithCompressedPaths(w, t uint) (h HAMT, err error) {
+	h, err = NewHAMT(w, t)
+	if err == nil {
+		h.root.compressPaths = true
+	}
+	return
+}
+
+// Create a new Merkle HAMT whose lower-level tables differ in size by
+// depth.  The parameters are as for NewHAMTWithWidths.
+func NewMerkleHAMTWithWidths(widths []uint, t uint) (h HAMT, err error) {
+	h, err = NewHAMTWithWidths(widths, t)
+	if err == nil {
+		h.root.initMerkle()
+	}
+	return
+}
+
+// Return whether this HAMT's root table is sparse.
+func (h HAMT) IsSparse() bool {
+	return h.root.groups != nil
+}
+
+// Return whether this HAMT's Tables may skip levels.
+func (h HAMT) HasCompressedPaths() bool {
+	return h.root.compressPaths
+}
+
+// Return whether this HAMT maintains digests.
+func (h HAMT) IsMerkle() bool {
+	return h.root.merkle
+}
+
+// Return the SHA-256 digest over the entire HAMT, or nil if this is not
+// a Merkle HAMT.  Two Merkle HAMTs with the same widths and t have
+// the same digest if and only if they contain the same keys and values.
+func (h HAMT) RootDigest() []byte {
+	return h.root.getDigest()
+}
+
+// Return a proof that the key k is or is not present in this Merkle
+// HAMT.  The proof can be checked using Verify and RootDigest().
+func (h HAMT) Prove(k KeyI) (*Proof, error) {
+	return h.root.prove(k)
+}
+
+// Serve this Merkle HAMT's state over rw to a replica calling PullSync,
+// until that replica ends the session.
+func (h HAMT) ServeSync(rw io.ReadWriter) error {
+	return h.root.serveSync(rw)
+}
+
+// Make this Merkle HAMT identical to the replica being served over rw,
+// exchanging only the leaves which differ.  Return the number of keys
+// inserted, replaced, or deleted.  Values copied from the other replica
+// are stored as byte slices.
+func (h HAMT) PullSync(rw io.ReadWriter) (uint, error) {
+	return h.root.pullSync(rw)
+}
+
 // Return t which determines the size of the root table (2^t).
 func (h HAMT) GetT() uint {
 	return h.root.t
 }
 
-// Return w which determines the size of lower-level tables (2^w).
+// Return w which determines the size of lower-level tables (2^w), or
+// of those at depth 1 if their sizes differ by depth.
 func (h HAMT) GetW() uint {
 	return h.root.w
 }
 
+// Return the widths of lower-level tables by depth, from depth 1; the
+// last width applies to all deeper tables.  For a HAMT created by
+// NewHAMT, this is just w.
+func (h HAMT) GetWidths() []uint {
+	return append([]uint(nil), h.root.schedule...)
+}
+
 // Return the number of leaf nodes in the HAMT.
 func (h HAMT) GetLeafCount() uint {
+	h.root.readLock()
+	defer h.root.readUnlock()
 	return h.root.getLeafCount()
 }
 
+// Return the most entries a leaf page may hold, or 0 if this HAMT has
+// no pages.
+func (h HAMT) GetPageSize() uint {
+	return h.root.pageSize
+}
+
 // Return the number of tables, including the root table, in the HAMT.
+// Leaf pages are not counted.
 func (h HAMT) GetTableCount() uint {
+	h.root.readLock()
+	defer h.root.readUnlock()
 	return h.root.getTableCount()
 }
 
+// Walk the HAMT, returning statistics on its structure.
+func (h HAMT) Stats() *Stats {
+	h.root.readLock()
+	defer h.root.readUnlock()
+	return h.root.getStats()
+}
+
+// Write the trie in Graphviz DOT format.  Each Table's node shows its
+// depth and bitmap, and each edge the index of the slot it leaves from.
+// opts may be nil, in which case the entire trie is rendered.
+func (h HAMT) WriteDOT(out io.Writer, opts *DumpOptions) error {
+	h.root.readLock()
+	defer h.root.readUnlock()
+	return h.root.dump(out, opts, true)
+}
+
+// Write the trie as an indented text tree, one line per node, each
+// prefixed by the index of the slot holding it.
+func (h HAMT) WriteText(out io.Writer, opts *DumpOptions) error {
+	h.root.readLock()
+	defer h.root.readUnlock()
+	return h.root.dump(out, opts, false)
+}
+
+// Check that the trie satisfies the HAMT invariants, returning a list
+// of any violations found.  The list is empty if all is well.
+func (h HAMT) Validate() []Violation {
+	h.root.readLock()
+	defer h.root.readUnlock()
+	return h.root.validate()
+}
+
 // If there is an entry with the key k in the HAMT, remove it.  If
 // there is no such entry, return NotFound.
-func (h HAMT) Delete(k KeyI) error {
-	return h.root.deleteLeaf(k)
+func (h HAMT) Delete(k KeyI) (err error) {
+	root := h.root
+	root.writeLock()
+	defer root.writeUnlock()
+	err = root.deleteLeaf(k)
+	if err == nil && root.maxEntries > 0 {
+		root.entries--
+	}
+	if debugValidate {
+		root.mustValidate()
+	}
+	if root.hook != nil {
+		root.hook(OpDelete, err)
+	}
+	return
 }
 
 // If there is an entry with the key k in the HAMT, return the value
 // associated with the key.  If there is no such entry, return nil.
 func (h HAMT) Find(k KeyI) (interface{}, error) {
-	return h.root.findLeaf(k)
+	if h.root.lock == nil && h.root.hook == nil {
+		return h.root.findLeaf(k)
+	}
+	return h.root.findObserved(k)
 }
 
-// Try to create an Leaf for the key/value pair..  If this succeeds,
-// try to insert the Leaf into the root table.
+// Return the value associated with StringKey(s), or nil if there is no
+// such entry.  Unlike Find(StringKey(s)), this does not allocate,
+// unless the HAMT is Synchronized, has a MetricsHook, or uses a hasher.
+func (h HAMT) FindString(s string) (interface{}, error) {
+	root := h.root
+	if root.lock != nil || root.hook != nil || root.hasher != nil {
+		return root.findObserved(StringKey(s))
+	}
+	k := StringKey(s)
+	return root.findHashed(k.Hashcode(), k), nil
+}
+
+// Insert the key/value pair into the HAMT, replacing any value already
+// associated with the key.  Neither the key nor the value may be nil.
+// In a Merkle HAMT, the digests along the path to the entry are updated.
+// If the HAMT has a limit on the number of entries and is full, a new
+// key is refused with MaxEntriesExceeded.
 func (h HAMT) Insert(k KeyI, v interface{}) (err error) {
-	leaf, err := NewLeaf(k, v)
+	root := h.root
+	root.writeLock()
+	defer root.writeUnlock()
+	var digest []byte
+	var isNew bool
+	if k == nil {
+		err = NilKey
+	} else if v == nil {
+		err = NilValue
+	} else if root.merkle {
+		digest, err = leafDigest(k, v)
+	}
+	if err == nil && root.maxEntries > 0 {
+		isNew, err = root.admit(k)
+	}
 	if err == nil {
-		err = h.root.insertLeaf(leaf)
+		if root.copyKeys {
+			k = copyKey(k)
+		}
+		err = root.insertLeaf(k, v, digest)
+		if err == nil && isNew {
+			root.entries++
+		}
+	}
+	if debugValidate {
+		root.mustValidate()
+	}
+	if root.hook != nil {
+		root.hook(OpInsert, err)
 	}
 	return
 }
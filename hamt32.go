@@ -0,0 +1,494 @@
+package hamt_go
+
+// hamt_go/hamt32.go
+
+import (
+	"fmt"
+	"io"
+)
+
+var _ = fmt.Print
+
+// A HAMT32 is a HAMT for small maps, using 32-bit hashcodes and bitmaps
+// where a HAMT uses 64-bit ones.  Its tables are much smaller than a
+// HAMT's: each holds two uint32 bitmaps, its entries, and its
+// subtables, and nothing else, leaving widths and shifts to the root.
+// w may be at most 5, so that a table's slots fit in a uint32, and t
+// at most 32.
+//
+// A key's 32-bit hashcode is its 64-bit Hashcode() folded in half.
+// With only 32 bits, distinct keys with the same hashcode are to be
+// expected once a map holds tens of thousands of keys.  Rather than
+// failing with MaxTableDepthExceeded, as a HAMT would, a HAMT32 keeps
+// such keys together in a collision bucket below the deepest table: a
+// table32 with no bitmaps whose entries are compared in turn.
+//
+// A HAMT32 has the Insert, Find, and Delete methods of a HAMT and the
+// same means of inspecting it, but is never a Merkle HAMT and has a
+// single width, a dense root, no leaf pages, and no compressed paths,
+// as its IsMerkle, GetWidths, IsSparse, GetPageSize, and
+// HasCompressedPaths report.
+type HAMT32 struct {
+	root *root32
+}
+
+type root32 struct {
+	w             uint   // tables have 2^w slots, the deepest perhaps fewer
+	t             uint   // root table has 2^t slots
+	maxTableDepth uint   // max depth of tables; buckets are one deeper
+	widths        []uint // widths[d] is w for tables at depth d; widths[0] = t
+	shifts        []uint // shifts[d] is how many hashcode bits lie above depth d
+	slotCount     uint
+	mask          uint32
+	slots         []HTNodeI // each nil, a *Leaf, or a *table32
+}
+
+// Return the 32-bit hashcode of a key.
+func hash32(key KeyI) uint32 {
+	hc := key.Hashcode()
+	return uint32(hc ^ hc>>32)
+}
+
+// Create a new HAMT32 with 2^t slots in its root table and 2^w slots in
+// lower-level tables.  If t equals zero, it defaults to w.  w may be
+// from 1 to 5 and t at most 32.
+func NewHAMT32(w, t uint) (h HAMT32, err error) {
+	if w == 0 {
+		err = ZeroLengthTables
+	} else if w > MAX_W32 {
+		err = MaxTable32SizeExceeded
+	} else {
+		if t == 0 {
+			t = w
+		}
+		if t > 32 {
+			err = MaxRoot32SizeExceeded
+		} else {
+			h = HAMT32{root: newRoot32(w, t)}
+		}
+	}
+	return
+}
+
+func newRoot32(w, t uint) *root32 {
+	// as in scheduleDepths, but for 32 bits and a single width
+	widths, shifts := []uint{t}, []uint{0}
+	for shift := t; shift < 32; shift += w {
+		if shift+w > 32 {
+			widths = append(widths, 32-shift)
+		} else {
+			widths = append(widths, w)
+		}
+		shifts = append(shifts, shift)
+	}
+	return &root32{
+		w:             w,
+		t:             t,
+		maxTableDepth: uint(len(widths) - 1),
+		widths:        widths,
+		shifts:        shifts,
+		slotCount:     1 << t,
+		mask:          uint32(uint64(1)<<t - 1),
+		slots:         make([]HTNodeI, 1<<t),
+	}
+}
+
+// Return t which determines the size of the root table (2^t).
+func (h HAMT32) GetT() uint {
+	return h.root.t
+}
+
+// Return w which determines the size of lower-level tables (2^w).
+func (h HAMT32) GetW() uint {
+	return h.root.w
+}
+
+// Return the widths of lower-level tables by depth, in canonical form:
+// for a HAMT32, always the single width w.
+func (h HAMT32) GetWidths() []uint {
+	return []uint{h.root.w}
+}
+
+// Return whether this HAMT32's root table is sparse: it never is.
+func (h HAMT32) IsSparse() bool {
+	return false
+}
+
+// Return whether this HAMT32 maintains digests: it never does.
+func (h HAMT32) IsMerkle() bool {
+	return false
+}
+
+// Return the most entries a leaf page may hold: a HAMT32 has no pages,
+// only collision buckets, so 0.
+func (h HAMT32) GetPageSize() uint {
+	return 0
+}
+
+// Return whether this HAMT32's tables may skip levels: they never do.
+func (h HAMT32) HasCompressedPaths() bool {
+	return false
+}
+
+// Return the number of leaf nodes in the HAMT32.
+func (h HAMT32) GetLeafCount() (count uint) {
+	for i := uint(0); i < h.root.slotCount; i++ {
+		switch node := h.root.slots[i].(type) {
+		case *Leaf:
+			count++
+		case *table32:
+			count += node.getLeafCount()
+		}
+	}
+	return
+}
+
+// Return the number of tables, including the root table and any
+// collision buckets, in the HAMT32.
+func (h HAMT32) GetTableCount() (count uint) {
+	count = 1
+	for i := uint(0); i < h.root.slotCount; i++ {
+		if table, ok := h.root.slots[i].(*table32); ok {
+			count += table.getTableCount()
+		}
+	}
+	return
+}
+
+// Walk the HAMT32, returning statistics on its structure.
+func (h HAMT32) Stats() *Stats {
+	return h.root.getStats()
+}
+
+// Write the trie in Graphviz DOT format, as HAMT.WriteDOT does.
+// Collision buckets are shown as tables labelled "bucket", their
+// entries numbered by position.
+func (h HAMT32) WriteDOT(out io.Writer, opts *DumpOptions) error {
+	return h.root.dump(out, opts, true)
+}
+
+// Write the trie as an indented text tree, as HAMT.WriteText does.
+func (h HAMT32) WriteText(out io.Writer, opts *DumpOptions) error {
+	return h.root.dump(out, opts, false)
+}
+
+// Check that the trie satisfies the HAMT invariants, returning a list
+// of any violations found.  The list is empty if all is well.
+func (h HAMT32) Validate() []Violation {
+	return h.root.validate()
+}
+
+// If there is an entry with the key k in the HAMT32, remove it.  If
+// there is no such entry, return NotFound.
+func (h HAMT32) Delete(k KeyI) (err error) {
+	if k == nil {
+		err = NilKey
+	} else {
+		err = h.root.deleteLeaf(k)
+	}
+	if debugValidate {
+		h.root.mustValidate()
+	}
+	return
+}
+
+// If there is an entry with the key k in the HAMT32, return the value
+// associated with the key.  If there is no such entry, return nil.
+func (h HAMT32) Find(k KeyI) (interface{}, error) {
+	if k == nil {
+		return nil, NilKey
+	}
+	return h.root.findLeaf(hash32(k), k), nil
+}
+
+// Return the value associated with StringKey(s), or nil if there is no
+// such entry.  Unlike Find(StringKey(s)), this does not allocate.
+func (h HAMT32) FindString(s string) (interface{}, error) {
+	k := StringKey(s)
+	return h.root.findLeaf(hash32(k), k), nil
+}
+
+// Insert the key/value pair into the HAMT32, replacing any value
+// already associated with the key.  Neither the key nor the value may
+// be nil.
+func (h HAMT32) Insert(k KeyI, v interface{}) (err error) {
+	if k == nil {
+		err = NilKey
+	} else if v == nil {
+		err = NilValue
+	} else {
+		h.root.insertLeaf(k, v)
+	}
+	if debugValidate {
+		h.root.mustValidate()
+	}
+	return
+}
+
+// Return the first occupied slot at or after slot i and its node, or
+// slotCount and nil if there is none, as Root.nextSlot does.
+func (root *root32) nextSlot(i uint) (uint, HTNodeI) {
+	for ; i < root.slotCount; i++ {
+		if root.slots[i] != nil {
+			return i, root.slots[i]
+		}
+	}
+	return root.slotCount, nil
+}
+
+// Return the value associated with key, whose 32-bit hashcode is hc,
+// or nil if there is none.
+func (root *root32) findLeaf(hc uint32, key KeyI) (value interface{}) {
+	switch node := root.slots[hc&root.mask].(type) {
+	case *table32:
+		value = node.findLeaf(root, hc>>root.t, 1, key)
+	case *Leaf:
+		if sameKey(node.Key, key) {
+			value = node.Value
+		}
+	}
+	return
+}
+
+// Insert an entry.  A Leaf is allocated only if the entry goes in a
+// root slot; deeper entries are held inline in tables.  This cannot
+// fail: keys whose hashcodes are the same go into a collision bucket.
+func (root *root32) insertLeaf(key KeyI, value interface{}) {
+	hc := hash32(key)
+	slotNbr := hc & root.mask
+	switch node := root.slots[slotNbr].(type) {
+	case nil:
+		root.slots[slotNbr] = &Leaf{Key: key, Value: value}
+	case *table32:
+		node.insertLeaf(root, hc>>root.t, 1, key, value)
+	case *Leaf:
+		if sameKey(node.Key, key) {
+			node.Value = value
+		} else {
+			// replace the leaf with a table holding both entries
+			tableDeeper := root.newTable(1, node.Key, node.Value)
+			tableDeeper.insertLeaf(root, hc>>root.t, 1, key, value)
+			root.slots[slotNbr] = tableDeeper
+		}
+	}
+}
+
+func (root *root32) deleteLeaf(key KeyI) (err error) {
+	hc := hash32(key)
+	slotNbr := hc & root.mask
+	switch node := root.slots[slotNbr].(type) {
+	case nil:
+		err = NotFound
+	case *Leaf:
+		if sameKey(node.Key, key) {
+			root.slots[slotNbr] = nil
+		} else {
+			err = NotFound
+		}
+	case *table32:
+		err = node.deleteLeaf(root, hc>>root.t, 1, key)
+		if err == nil && len(node.nodes) == 0 {
+			// keep the trie in canonical form
+			switch len(node.values) {
+			case 0:
+				root.slots[slotNbr] = nil
+			case 1:
+				root.slots[slotNbr] = &Leaf{Key: node.keys[0], Value: node.values[0]}
+			}
+		}
+	}
+	return
+}
+
+// STATISTICS AND VALIDATION ////////////////////////////////////////
+
+// Collect statistics by walking the entire trie.  Collision buckets
+// are counted as tables but left out of TableFill.
+func (root *root32) getStats() (st *Stats) {
+	st = &Stats{
+		W:             root.w,
+		Widths:        []uint{root.w},
+		T:             root.t,
+		TableCount:    1,
+		LeavesAtDepth: []uint{0},
+		TablesAtDepth: []uint{1},
+		TableFill:     make([]uint, (1<<root.w)+1),
+		RootSlotCount: root.slotCount,
+		Bytes:         sizeofRoot32 + uint64(root.slotCount)*sizeofNodeI,
+	}
+	var depthSum uint64
+	for i := uint(0); i < root.slotCount; i++ {
+		switch node := root.slots[i].(type) {
+		case *Leaf:
+			st.RootLeaves++
+			st.Bytes += sizeofLeaf
+			depthSum += st.addEntry32(0)
+		case *table32:
+			st.RootTables++
+			depthSum += st.addTable32(root, node, 1)
+		}
+	}
+	if st.LeafCount > 0 {
+		st.AvgDepth = float64(depthSum) / float64(st.LeafCount)
+		st.BytesPerEntry = float64(st.Bytes) / float64(st.LeafCount)
+	}
+	return
+}
+
+// Add a table at the depth specified, and anything below it, to the
+// statistics, returning the sum of the lookup depths of its entries.
+func (st *Stats) addTable32(root *root32, table *table32, depth uint) (
+	depthSum uint64) {
+
+	if uint(len(st.TablesAtDepth)) <= depth {
+		st.TablesAtDepth = append(st.TablesAtDepth, 0)
+		st.LeavesAtDepth = append(st.LeavesAtDepth, 0)
+	}
+	st.TableCount++
+	st.TablesAtDepth[depth]++
+	if depth <= root.maxTableDepth {
+		st.TableFill[len(table.values)+len(table.nodes)]++
+	}
+	st.Bytes += sizeofTable32 +
+		uint64(cap(table.keys)+cap(table.values))*sizeofNodeI +
+		uint64(cap(table.nodes))*sizeofPtr
+	for i := 0; i < len(table.values); i++ {
+		depthSum += st.addEntry32(depth)
+	}
+	for i := 0; i < len(table.nodes); i++ {
+		depthSum += st.addTable32(root, table.nodes[i], depth+1)
+	}
+	return
+}
+
+// Add an entry at the depth specified, returning its lookup depth.
+func (st *Stats) addEntry32(depth uint) uint64 {
+	st.LeafCount++
+	st.LeavesAtDepth[depth]++
+	if depth > st.MaxDepth {
+		st.MaxDepth = depth
+	}
+	return uint64(depth)
+}
+
+// Check the entire trie, returning every violation found.  The checks
+// are as for a HAMT, and also that each collision bucket holds at
+// least two entries, all with the same hashcode and none with the
+// same key.
+func (root *root32) validate() []Violation {
+	v := &validator{}
+	if uint(len(root.slots)) != root.slotCount {
+		v.add(nil, "root has %d slots, expected %d",
+			len(root.slots), root.slotCount)
+		return v.violations
+	}
+	for i := uint(0); i < root.slotCount; i++ {
+		path := []uint{i}
+		switch node := root.slots[i].(type) {
+		case *Leaf:
+			v.checkEntry32(path, node.Key, node.Value, uint32(i), root.t)
+		case *table32:
+			v.checkTable32(path, root, node, uint32(i), root.t, 1)
+		}
+	}
+	return v.violations
+}
+
+// In debug builds, called after every operation which modifies the
+// trie.
+func (root *root32) mustValidate() {
+	violations := root.validate()
+	if len(violations) > 0 {
+		panic(fmt.Sprintf("HAMT32 invariant violated: %v", violations))
+	}
+}
+
+// Check an entry whose path leaves the low-order shift bits of its
+// hashcode equal to prefix.
+func (v *validator) checkEntry32(path []uint, key KeyI, value interface{},
+	prefix uint32, shift uint) {
+
+	if key == nil || value == nil {
+		v.add(path, "leaf has nil key or value")
+	} else if hc := hash32(key); hc&uint32(uint64(1)<<shift-1) != prefix {
+		v.add(path, "leaf hashcode %08x does not match path", hc)
+	}
+}
+
+// Check a table at the depth given, and anything below it.
+func (v *validator) checkTable32(path []uint, root *root32, table *table32,
+	prefix uint32, shift uint, depth uint) {
+
+	if len(table.keys) != len(table.values) {
+		v.add(path, "table has %d keys, %d values",
+			len(table.keys), len(table.values))
+		return
+	}
+	if depth > root.maxTableDepth {
+		v.checkBucket32(path, table, prefix)
+		return
+	}
+	w := root.widths[depth]
+	if table.dataMap&table.nodeMap != 0 {
+		v.add(path, "slots %08x marked as holding both leaf and table",
+			table.dataMap&table.nodeMap)
+		return
+	}
+	dataCount := bitmapCount([]uint64{uint64(table.dataMap)})
+	nodeCount := bitmapCount([]uint64{uint64(table.nodeMap)})
+	if dataCount != len(table.keys) || nodeCount != len(table.nodes) {
+		v.add(path, "bitmap popcounts %d/%d but table has %d entries, %d tables",
+			dataCount, nodeCount, len(table.keys), len(table.nodes))
+		return
+	}
+	if uint64(table.dataMap|table.nodeMap)>>(uint64(1)<<w) != 0 {
+		v.add(path, "bitmap %08x has bits set beyond 2^w",
+			table.dataMap|table.nodeMap)
+	}
+	if len(table.nodes) == 0 {
+		switch len(table.values) {
+		case 0:
+			v.add(path, "table is empty")
+		case 1:
+			v.add(path, "table holds a single leaf")
+		}
+	}
+	var dataNbr, nodeNbr int
+	for n := uint32(0); n < uint32(1)<<w; n++ {
+		childPath := append(path[:len(path):len(path)], uint(n))
+		flag := uint32(1) << n
+		if table.dataMap&flag != 0 {
+			v.checkEntry32(childPath, table.keys[dataNbr], table.values[dataNbr],
+				prefix|n<<shift, shift+w)
+			dataNbr++
+		} else if table.nodeMap&flag != 0 {
+			child := table.nodes[nodeNbr]
+			nodeNbr++
+			if child == nil {
+				v.add(childPath, "nil in table slot")
+			} else {
+				v.checkTable32(childPath, root, child, prefix|n<<shift,
+					shift+w, depth+1)
+			}
+		}
+	}
+}
+
+// Check a collision bucket, all of whose entries should have the
+// hashcode prefix.
+func (v *validator) checkBucket32(path []uint, table *table32, prefix uint32) {
+	if table.dataMap != 0 || table.nodeMap != 0 || len(table.nodes) != 0 {
+		v.add(path, "collision bucket has bitmaps or subtables")
+	}
+	if len(table.keys) < 2 {
+		v.add(path, "collision bucket holds %d leaves", len(table.keys))
+	}
+	for i := 0; i < len(table.keys); i++ {
+		v.checkEntry32(path, table.keys[i], table.values[i], prefix, 32)
+		for j := 0; j < i; j++ {
+			if table.keys[i] != nil && sameKey(table.keys[i], table.keys[j]) {
+				v.add(path, "collision bucket holds the same key twice")
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package hamt_go
+
+// hamt_go/hamt32_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+var _ = fmt.Print
+
+// A key whose hashcode is set by the test, so that keys can be made to
+// collide in all 32 bits.
+type hc32Key struct {
+	n  int
+	hc uint64
+}
+
+func (k hc32Key) Hashcode() uint64 {
+	return k.hc
+}
+
+func (s *XLSuite) TestHAMT32Ctor(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HAMT32_CTOR")
+	}
+	_, err := NewHAMT32(0, 4)
+	c.Assert(err, Equals, ZeroLengthTables)
+	_, err = NewHAMT32(MAX_W32+1, 4)
+	c.Assert(err, Equals, MaxTable32SizeExceeded)
+	_, err = NewHAMT32(5, 33)
+	c.Assert(err, Equals, MaxRoot32SizeExceeded)
+	h, err := NewHAMT32(4, 0)
+	c.Assert(err, IsNil)
+	c.Assert(h.GetW(), Equals, uint(4))
+	c.Assert(h.GetT(), Equals, uint(4))
+	c.Assert(h.GetLeafCount(), Equals, uint(0))
+	c.Assert(h.GetTableCount(), Equals, uint(1))
+	c.Assert(h.GetWidths(), DeepEquals, []uint{4})
+	c.Assert(h.IsSparse(), Equals, false)
+	c.Assert(h.IsMerkle(), Equals, false)
+	c.Assert(h.GetPageSize(), Equals, uint(0))
+	c.Assert(h.HasCompressedPaths(), Equals, false)
+	c.Assert(h.Insert(nil, 1), Equals, NilKey)
+	c.Assert(h.Insert(StringKey("abc"), nil), Equals, NilValue)
+	c.Assert(h.Delete(StringKey("abc")), Equals, NotFound)
+
+	// the last level takes whatever bits remain
+	c.Assert(h.root.widths, DeepEquals, []uint{4, 4, 4, 4, 4, 4, 4, 4})
+	h, err = NewHAMT32(5, 4)
+	c.Assert(err, IsNil)
+	c.Assert(h.root.maxTableDepth, Equals, uint(6))
+	c.Assert(h.root.widths[6], Equals, uint(3))
+	c.Assert(h.root.shifts[6], Equals, uint(29))
+}
+
+func (s *XLSuite) TestHAMT32(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HAMT32")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestHAMT32(c, rng, 1, 1)
+	s.doTestHAMT32(c, rng, 3, 4)
+	s.doTestHAMT32(c, rng, 5, 5)
+	s.doTestHAMT32(c, rng, 4, 12)
+}
+
+func (s *XLSuite) doTestHAMT32(c *C, rng *xr.PRNG, w, t uint) {
+	const KEY_COUNT = 4096
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	h, err := NewHAMT32(w, t)
+	c.Assert(err, IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+	}
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT))
+	c.Assert(h.Validate(), HasLen, 0)
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := h.Find(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, &rawKeys[i])
+	}
+	// replacing a value changes nothing else
+	c.Assert(h.Insert(bKeys[0], "x"), IsNil)
+	value, err := h.Find(bKeys[0])
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "x")
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT))
+
+	// tables are smaller than those of a HAMT with the same keys
+	if w > 1 {
+		h64, err := NewHAMT(w, t)
+		c.Assert(err, IsNil)
+		for i := 0; i < KEY_COUNT; i++ {
+			c.Assert(h64.Insert(bKeys[i], &rawKeys[i]), IsNil)
+		}
+		st, st64 := h.Stats(), h64.Stats()
+		c.Assert(st.LeafCount, Equals, uint(KEY_COUNT))
+		c.Assert(st.TableCount, Equals, h.GetTableCount())
+		c.Assert(st.Bytes < st64.Bytes, Equals, true)
+	}
+
+	// deleting the keys in random order leaves a valid trie, then an
+	// empty one
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Delete(bKeys[perm[i]]), IsNil)
+		c.Assert(h.Delete(bKeys[perm[i]]), Equals, NotFound)
+		if i == KEY_COUNT/2 {
+			c.Assert(h.Validate(), HasLen, 0)
+		}
+	}
+	c.Assert(h.GetLeafCount(), Equals, uint(0))
+	c.Assert(h.GetTableCount(), Equals, uint(1))
+}
+
+func (s *XLSuite) TestHAMT32Collisions(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HAMT32_COLLISIONS")
+	}
+	s.doTestHAMT32Collisions(c, 5, 4)
+	s.doTestHAMT32Collisions(c, 3, 16)
+}
+
+func (s *XLSuite) doTestHAMT32Collisions(c *C, w, t uint) {
+	// groups of four keys whose 64-bit hashcodes differ but fold to the
+	// same 32 bits, and a key sharing only their low 16 bits
+	var keys []hc32Key
+	for g := uint64(0); g < 3; g++ {
+		folded := 0x5a5a0000 | g
+		for hi := uint64(0); hi < 4; hi++ {
+			keys = append(keys, hc32Key{len(keys), hi<<32 | hi ^ folded})
+		}
+	}
+	keys = append(keys, hc32Key{len(keys), 0x12340000})
+
+	h, err := NewHAMT32(w, t)
+	c.Assert(err, IsNil)
+	for i := 0; i < len(keys); i++ {
+		c.Assert(h.Insert(keys[i], i), IsNil)
+	}
+	c.Assert(hash32(keys[0]), Equals, hash32(keys[3]))
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(h.GetLeafCount(), Equals, uint(len(keys)))
+	for i := 0; i < len(keys); i++ {
+		value, err := h.Find(keys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+	value, err := h.Find(hc32Key{99, keys[0].hc})
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+	c.Assert(h.Delete(hc32Key{99, keys[0].hc}), Equals, NotFound)
+
+	// buckets are dumped as tables, their entries numbered by position
+	st := h.Stats()
+	var buf bytes.Buffer
+	c.Assert(h.WriteText(&buf, nil), IsNil)
+	text := buf.String()
+	c.Assert(strings.HasPrefix(text, fmt.Sprintf("root w %d t %d", w, t)),
+		Equals, true)
+	c.Assert(strings.Count(text, "\n"), Equals, int(st.LeafCount+st.TableCount))
+	c.Assert(strings.Count(text, "] leaf "), Equals, len(keys))
+	c.Assert(strings.Count(text, "] bucket depth "), Equals, 3)
+	c.Assert(strings.Contains(text, fmt.Sprintf("leaf %08x", hash32(keys[0]))),
+		Equals, true)
+	buf.Reset()
+	c.Assert(h.WriteDOT(&buf, nil), IsNil)
+	dot := buf.String()
+	c.Assert(strings.HasPrefix(dot, "digraph HAMT {\n"), Equals, true)
+	c.Assert(strings.Count(dot, " -> "), Equals, int(st.LeafCount+st.TableCount-1))
+	buf.Reset()
+	c.Assert(h.WriteText(&buf, &DumpOptions{MaxDepth: 1}), IsNil)
+	c.Assert(strings.Contains(buf.String(), "bucket"), Equals, false)
+	c.Assert(strings.Contains(buf.String(), "more"), Equals, true)
+
+	// replacing a value in a bucket
+	c.Assert(h.Insert(keys[1], "x"), IsNil)
+	value, _ = h.Find(keys[1])
+	c.Assert(value, Equals, "x")
+	c.Assert(h.GetLeafCount(), Equals, uint(len(keys)))
+
+	// emptying the buckets collapses them
+	for i := 0; i < len(keys); i++ {
+		c.Assert(h.Delete(keys[i]), IsNil)
+		c.Assert(h.Validate(), HasLen, 0)
+	}
+	c.Assert(h.GetTableCount(), Equals, uint(1))
+}
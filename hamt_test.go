@@ -20,7 +20,8 @@ func (s *XLSuite) TestHAMTCtor(c *C) {
 	t := uint(0)
 	s.doTestHAMTCtor(c, uint(4), t)
 	s.doTestHAMTCtor(c, uint(5), t)
-	s.doTestHAMTCtor(c, uint(6), t) // MAX_W
+	s.doTestHAMTCtor(c, uint(6), t)
+	s.doTestHAMTCtor(c, uint(8), t) // MAX_W
 }
 func (s *XLSuite) doTestHAMTCtor(c *C, w, t uint) {
 	h, err := NewHAMT(w, t)
@@ -351,4 +352,6 @@ func (s *XLSuite) doTestHamtEntrySplittingInserts(c *C, rng *xr.PRNG,
 		c.Assert(err, IsNil)
 		c.Assert(value, IsNil)
 	}
+	// deletions remove tables which they leave empty
+	c.Assert(h.GetTableCount(), Equals, uint(1))
 }
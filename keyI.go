@@ -2,7 +2,137 @@ package hamt_go
 
 // hamt_go/keyI.go
 
+import (
+	"bytes"
+)
+
 // A Key is anything that returns an unsigned 64-bit value.
 type KeyI interface {
 	Hashcode() uint64
 }
+
+// Return whether two keys are equal.  Keys of different types are
+// never equal; keys of types not defined in this package are compared
+// with ==.  BytesKeys are tested for first and inline, so that the
+// commonest comparison costs no more than a type assertion.
+func sameKey(a, b KeyI) bool {
+	if ka, ok := a.(BytesKey); ok {
+		kb, ok := b.(BytesKey)
+		return ok && bytes.Equal(ka.Slice, kb.Slice)
+	}
+	return sameOtherKey(a, b)
+}
+
+func sameOtherKey(a, b KeyI) bool {
+	switch ka := a.(type) {
+	case StringKey:
+		kb, ok := b.(StringKey)
+		return ok && ka == kb
+	case Uint64Key:
+		kb, ok := b.(Uint64Key)
+		return ok && ka == kb
+	case DigestKey:
+		kb, ok := b.(DigestKey)
+		return ok && ka == kb
+	case TupleKey:
+		kb, ok := b.(TupleKey)
+		return ok && ka.equal(kb)
+	}
+	return a == b // a key of some other type must be comparable
+}
+
+// Return a copy of the key sharing no bytes with it: a BytesKey's
+// slice is copied, as are those of any BytesKeys in a TupleKey.  Keys
+// of the other types in this package cannot change and are returned
+// as they are, as are keys of types not defined here.
+func copyKey(k KeyI) KeyI {
+	switch key := k.(type) {
+	case BytesKey:
+		return BytesKey{Slice: append([]byte(nil), key.Slice...)}
+	case TupleKey:
+		elems := make([]KeyI, len(key.elems))
+		for i := 0; i < len(elems); i++ {
+			elems[i] = copyKey(key.elems[i])
+		}
+		return TupleKey{elems: elems, hc: key.hc}
+	}
+	return k
+}
+
+// Each key encoded by EncodeKey begins with a byte identifying its type.
+const (
+	bytesKeyTag  = byte(1)
+	stringKeyTag = byte(2)
+	uint64KeyTag = byte(3)
+	digestKeyTag = byte(4)
+	tupleKeyTag  = byte(5)
+)
+
+// Serialize a key of any of the types defined in this package as a
+// byte identifying its type followed by the key's MarshalBinary
+// encoding.  DecodeKey reverses this.
+func EncodeKey(k KeyI) (data []byte, err error) {
+	var tag byte
+	var body []byte
+	switch key := k.(type) {
+	case BytesKey:
+		tag = bytesKeyTag
+		body, err = key.MarshalBinary()
+	case StringKey:
+		tag = stringKeyTag
+		body, err = key.MarshalBinary()
+	case Uint64Key:
+		tag = uint64KeyTag
+		body, err = key.MarshalBinary()
+	case DigestKey:
+		tag = digestKeyTag
+		body, err = key.MarshalBinary()
+	case TupleKey:
+		tag = tupleKeyTag
+		body, err = key.MarshalBinary()
+	case nil:
+		err = NilKey
+	default:
+		err = UnsupportedKeyType
+	}
+	if err == nil {
+		data = append([]byte{tag}, body...)
+	}
+	return
+}
+
+// Deserialize a key encoded by EncodeKey.
+func DecodeKey(data []byte) (k KeyI, err error) {
+	if len(data) == 0 {
+		return nil, BadKeyEncoding
+	}
+	body := data[1:]
+	switch data[0] {
+	case bytesKeyTag:
+		var key BytesKey
+		err = key.UnmarshalBinary(body)
+		k = key
+	case stringKeyTag:
+		var key StringKey
+		err = key.UnmarshalBinary(body)
+		k = key
+	case uint64KeyTag:
+		var key Uint64Key
+		err = key.UnmarshalBinary(body)
+		k = key
+	case digestKeyTag:
+		var key DigestKey
+		err = key.UnmarshalBinary(body)
+		k = key
+	case tupleKeyTag:
+		var key TupleKey
+		err = key.UnmarshalBinary(body)
+		k = key
+	default:
+		err = BadKeyEncoding
+	}
+	if err != nil {
+		k = nil
+	}
+	return
+}
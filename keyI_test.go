@@ -0,0 +1,127 @@
+package hamt_go
+
+// hamt_go/keyI_test.go
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	. "gopkg.in/check.v1"
+)
+
+var _ = fmt.Print
+
+// Return one key of each type defined in this package.
+func (s *XLSuite) makeKeyOfEachType(c *C) []KeyI {
+	bKey, err := NewBytesKey([]byte("0123456789abcdef"))
+	c.Assert(err, IsNil)
+	d1 := sha1.Sum([]byte("abc"))
+	dKey1, err := NewDigestKey(d1[:])
+	c.Assert(err, IsNil)
+	d256 := sha256.Sum256([]byte("abc"))
+	dKey256, err := NewDigestKey(d256[:])
+	c.Assert(err, IsNil)
+	inner, err := NewTupleKey(StringKey("inner"), Uint64Key(7))
+	c.Assert(err, IsNil)
+	tKey, err := NewTupleKey(bKey, StringKey(""), Uint64Key(1<<63), dKey1,
+		inner)
+	c.Assert(err, IsNil)
+	return []KeyI{bKey, StringKey("a string"), StringKey(""), Uint64Key(0),
+		Uint64Key(42), dKey1, dKey256, inner, tKey}
+}
+
+func (s *XLSuite) TestKeyEncoding(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_KEY_ENCODING")
+	}
+	keys := s.makeKeyOfEachType(c)
+	for i := 0; i < len(keys); i++ {
+		data, err := EncodeKey(keys[i])
+		c.Assert(err, IsNil)
+		decoded, err := DecodeKey(data)
+		c.Assert(err, IsNil)
+		c.Assert(sameKey(decoded, keys[i]), Equals, true)
+		c.Assert(decoded.Hashcode(), Equals, keys[i].Hashcode())
+		for j := 0; j < len(keys); j++ {
+			c.Assert(sameKey(decoded, keys[j]), Equals, i == j)
+		}
+		// a truncated encoding never decodes to the same key
+		if len(data) > 1 {
+			decoded, err = DecodeKey(data[:len(data)-1])
+			c.Assert(err != nil || !sameKey(decoded, keys[i]), Equals, true)
+		}
+	}
+	_, err := EncodeKey(nil)
+	c.Assert(err, Equals, NilKey)
+	_, err = DecodeKey(nil)
+	c.Assert(err, Equals, BadKeyEncoding)
+	_, err = DecodeKey([]byte{99, 1, 2})
+	c.Assert(err, Equals, BadKeyEncoding)
+}
+
+func (s *XLSuite) TestDigestAndTupleKeys(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_DIGEST_AND_TUPLE_KEYS")
+	}
+	_, err := NewDigestKey(nil)
+	c.Assert(err, Equals, NilKey)
+	_, err = NewDigestKey(make([]byte, 21))
+	c.Assert(err, Equals, BadDigestLength)
+	d := make([]byte, 32)
+	d[0] = 1
+	dKey, err := NewDigestKey(d)
+	c.Assert(err, IsNil)
+	d[0] = 2 // the key holds its own copy
+	c.Assert(dKey.Bytes()[0], Equals, byte(1))
+	c.Assert(dKey.Hashcode(), Equals, uint64(1))
+
+	_, err = NewTupleKey()
+	c.Assert(err, Equals, NilKey)
+	_, err = NewTupleKey(StringKey("a"), nil)
+	c.Assert(err, Equals, NilKey)
+	ab, err := NewTupleKey(StringKey("a"), StringKey("b"))
+	c.Assert(err, IsNil)
+	ba, err := NewTupleKey(StringKey("b"), StringKey("a"))
+	c.Assert(err, IsNil)
+	ab2, err := NewTupleKey(StringKey("a"), StringKey("b"))
+	c.Assert(err, IsNil)
+	c.Assert(ab.Len(), Equals, 2)
+	c.Assert(ab.Elem(1), Equals, KeyI(StringKey("b")))
+	c.Assert(ab.Hashcode(), Not(Equals), ba.Hashcode())
+	c.Assert(sameKey(ab, ba), Equals, false)
+	c.Assert(sameKey(ab, ab2), Equals, true)
+}
+
+// Keys of every type can share a HAMT.
+func (s *XLSuite) TestMixedKeysHAMT(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MIXED_KEYS_HAMT")
+	}
+	keys := s.makeKeyOfEachType(c)
+	h, err := NewHAMT(4, 4)
+	c.Assert(err, IsNil)
+	for i := 0; i < 256; i++ {
+		k, err := NewTupleKey(Uint64Key(i), StringKey(fmt.Sprintf("%d", i)))
+		c.Assert(err, IsNil)
+		keys = append(keys, k, Uint64Key(1000+i))
+	}
+	for i := 0; i < len(keys); i++ {
+		c.Assert(h.Insert(keys[i], i), IsNil)
+	}
+	c.Assert(h.GetLeafCount(), Equals, uint(len(keys)))
+	c.Assert(len(h.Validate()), Equals, 0)
+	for i := 0; i < len(keys); i++ {
+		// look each key up by a decoded copy
+		data, err := EncodeKey(keys[i])
+		c.Assert(err, IsNil)
+		k, err := DecodeKey(data)
+		c.Assert(err, IsNil)
+		value, err := h.Find(k)
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+	for i := 0; i < len(keys); i++ {
+		c.Assert(h.Delete(keys[i]), IsNil)
+	}
+	c.Assert(h.GetLeafCount(), Equals, uint(0))
+}
@@ -3,8 +3,9 @@ package hamt_go
 // hamt_go/leaf.go
 
 type Leaf struct {
-	Key   KeyI
-	Value interface{}
+	Key    KeyI
+	Value  interface{}
+	digest []byte // nil unless the HAMT is a Merkle HAMT
 }
 
 func NewLeaf(key KeyI, value interface{}) (leaf *Leaf, err error) {
@@ -0,0 +1,212 @@
+package hamt_go
+
+// hamt_go/merkle.go
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+)
+
+// In a Merkle HAMT every Leaf, every Table, and the Root carry a SHA-256
+// digest.  A Leaf's digest covers its key and value; a Table's digest
+// covers its bitmap and the digests of its children, in slot order.
+// The 2^t slots of the Root are covered by a binary Merkle tree, so
+// that a change to one slot costs t hashes rather than 2^t.  The HAMT
+// then has a single digest which changes whenever any key or value
+// changes.
+//
+// Each kind of node digested is prefixed by a distinct tag byte, so that
+// for example a Leaf can never be passed off as a Table.
+const (
+	leafTag  = byte(0)
+	tableTag = byte(1)
+	rootTag  = byte(2)
+	innerTag = byte(3) // node in the binary tree over the Root's slots
+	emptyTag = byte(4) // unoccupied Root slot
+)
+
+// Return the bytes used to represent a key in a Merkle HAMT.
+func keyBytes(k KeyI) (b []byte, err error) {
+	switch key := k.(type) {
+	case BytesKey:
+		b = key.Slice
+	default:
+		err = UnsupportedKeyType
+	}
+	return
+}
+
+// Return the bytes used to represent a value in a Merkle HAMT.  Values
+// must be byte slices, pointers to byte slices, strings, or implement
+// encoding.BinaryMarshaler.
+func valueBytes(v interface{}) (b []byte, err error) {
+	switch val := v.(type) {
+	case []byte:
+		b = val
+	case *[]byte:
+		b = *val
+	case string:
+		b = []byte(val)
+	case encoding.BinaryMarshaler:
+		b, err = val.MarshalBinary()
+	default:
+		err = UnsupportedValueType
+	}
+	return
+}
+
+// Return SHA-256 of the value's serialization.
+func valueDigest(v interface{}) (digest []byte, err error) {
+	b, err := valueBytes(v)
+	if err == nil {
+		d := sha256.Sum256(b)
+		digest = d[:]
+	}
+	return
+}
+
+// Digest a serialized key together with the digest of a value.  The
+// value is represented by its digest so that a proof can show a leaf
+// without carrying the value itself.
+func calcLeafDigest(kBytes, vDigest []byte) []byte {
+	var lenBuf [8]byte
+	binary.BigEndian.PutUint64(lenBuf[:], uint64(len(kBytes)))
+	d := sha256.New()
+	d.Write([]byte{leafTag})
+	d.Write(lenBuf[:])
+	d.Write(kBytes)
+	d.Write(vDigest)
+	return d.Sum(nil)
+}
+
+// Return the digest of a leaf with the key and value specified.
+func leafDigest(k KeyI, v interface{}) (digest []byte, err error) {
+	kBytes, err := keyBytes(k)
+	if err == nil {
+		var vDigest []byte
+		vDigest, err = valueDigest(v)
+		if err == nil {
+			digest = calcLeafDigest(kBytes, vDigest)
+		}
+	}
+	return
+}
+
+// Digest a Table given its bitmap, one word per 64 slots, and the
+// digests of its children in slot order.  A Table of 64 or fewer slots
+// has a one-word bitmap, so its digest is as it was before Tables could
+// be wider.
+func calcTableDigest(bitmap []uint64, children [][]byte) []byte {
+	var buf [8]byte
+	d := sha256.New()
+	d.Write([]byte{tableTag})
+	for i := 0; i < len(bitmap); i++ {
+		binary.BigEndian.PutUint64(buf[:], bitmap[i])
+		d.Write(buf[:])
+	}
+	for i := 0; i < len(children); i++ {
+		d.Write(children[i])
+	}
+	return d.Sum(nil)
+}
+
+// Digest a node in the binary tree over the Root's slots.
+func calcInnerDigest(left, right []byte) []byte {
+	d := sha256.New()
+	d.Write([]byte{innerTag})
+	d.Write(left)
+	d.Write(right)
+	return d.Sum(nil)
+}
+
+// Digest the Root given the top of the binary tree over its slots.  The
+// table parameters are included, because they determine the shape of
+// the trie.  Any widths after the first follow t, so that the digest
+// of a trie with a single width is unchanged.
+func calcRootDigest(schedule []uint, t uint, top []byte) []byte {
+	d := sha256.New()
+	d.Write([]byte{rootTag, byte(schedule[0]), byte(t)})
+	for i := 1; i < len(schedule); i++ {
+		d.Write([]byte{byte(schedule[i])})
+	}
+	d.Write(top)
+	return d.Sum(nil)
+}
+
+// The digest of an unoccupied slot in the Root.
+func calcEmptyDigest() []byte {
+	d := sha256.Sum256([]byte{emptyTag})
+	return d[:]
+}
+
+// Return the digest of a node, which must be either a *Leaf or a *Table.
+func nodeDigest(node HTNodeI) []byte {
+	if node.IsLeaf() {
+		return node.(*Leaf).digest
+	}
+	return node.(*Table).digest
+}
+
+// Calculate a Table's digest from those of its children, taken in slot
+// order whether they are entries or subtables.
+func (table *Table) calcDigest() []byte {
+	children := make([][]byte, 0, table.usedSlots())
+	var dataNbr, nodeNbr int
+	var bitmap [maxBitmapWords]uint64
+	dataMap, nodeMap, n := table.bitmaps()
+	for i := 0; i < n; i++ {
+		bitmap[i] = dataMap[i] | nodeMap[i]
+		for word := bitmap[i]; word != 0; word &= word - 1 {
+			if dataMap[i]&(word&-word) != 0 {
+				children = append(children, table.digests[dataNbr])
+				dataNbr++
+			} else {
+				children = append(children, table.nodes[nodeNbr].digest)
+				nodeNbr++
+			}
+		}
+	}
+	return calcTableDigest(bitmap[:n], children)
+}
+
+// Build the binary tree over the Root's slots, all of which must be
+// empty.  tree[1] is the top of the tree; the children of tree[i] are
+// tree[2i] and tree[2i+1]; and the digest of slot n is in
+// tree[slotCount+n].
+func (root *Root) initMerkle() {
+	root.merkle = true
+	root.tree = make([][]byte, 2*root.slotCount)
+	digest := calcEmptyDigest()
+	for first := root.slotCount; first > 0; first >>= 1 {
+		for i := first; i < 2*first; i++ {
+			root.tree[i] = digest
+		}
+		digest = calcInnerDigest(digest, digest)
+	}
+}
+
+// Recalculate the digests along the path from a Root slot to the top
+// of the binary tree.
+func (root *Root) updateSlotDigest(slotNbr uint) {
+	i := root.slotCount + slotNbr
+	node := root.slot(uint64(slotNbr))
+	if node == nil {
+		root.tree[i] = calcEmptyDigest()
+	} else {
+		root.tree[i] = nodeDigest(node)
+	}
+	for i > 1 {
+		i >>= 1
+		root.tree[i] = calcInnerDigest(root.tree[2*i], root.tree[2*i+1])
+	}
+}
+
+// Return the digest over the entire HAMT, or nil if the Root does not
+// maintain digests.
+func (root *Root) getDigest() (digest []byte) {
+	if root.merkle {
+		digest = calcRootDigest(root.schedule, root.t, root.tree[1])
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package hamt_go
+
+// hamt_go/merkle_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestMerkleCtor(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MERKLE_CTOR")
+	}
+	h, err := NewHAMT(5, 5)
+	c.Assert(err, IsNil)
+	c.Assert(h.IsMerkle(), Equals, false)
+	c.Assert(h.RootDigest(), IsNil)
+
+	m, err := NewMerkleHAMT(5, 5)
+	c.Assert(err, IsNil)
+	c.Assert(m.IsMerkle(), Equals, true)
+	c.Assert(len(m.RootDigest()), Equals, 32)
+
+	// an empty HAMT's digest depends upon w and t
+	m2, err := NewMerkleHAMT(5, 5)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(m.RootDigest(), m2.RootDigest()), Equals, true)
+	m3, err := NewMerkleHAMT(5, 6)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(m.RootDigest(), m3.RootDigest()), Equals, false)
+
+	// values which cannot be serialized are rejected
+	key, err := NewBytesKey(make([]byte, 8))
+	c.Assert(err, IsNil)
+	n := 42
+	err = m.Insert(key, &n)
+	c.Assert(err, Equals, UnsupportedValueType)
+	c.Assert(m.GetLeafCount(), Equals, uint(0))
+}
+
+// ------------------------------------------------------------------
+
+func (s *XLSuite) TestMerkleDigests(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MERKLE_DIGESTS")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestMerkleDigests(c, rng, 5, 4)
+	s.doTestMerkleDigests(c, rng, 6, 6)
+	s.doTestMerkleDigests(c, rng, 4, 8)
+	s.doTestMerkleDigests(c, rng, 7, 6)
+}
+
+func (s *XLSuite) doTestMerkleDigests(c *C, rng *xr.PRNG, w, t uint) {
+	const KEY_COUNT = 512
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	h, err := NewMerkleHAMT(w, t)
+	c.Assert(err, IsNil)
+
+	// every insertion changes the digest
+	digests := make([][]byte, KEY_COUNT+1)
+	digests[0] = h.RootDigest()
+	for i := 0; i < KEY_COUNT; i++ {
+		err = h.Insert(bKeys[i], &rawKeys[i])
+		c.Assert(err, IsNil)
+		digests[i+1] = h.RootDigest()
+		c.Assert(bytes.Equal(digests[i], digests[i+1]), Equals, false)
+	}
+	full := digests[KEY_COUNT]
+
+	// replacing a value changes the digest; putting it back restores it
+	ndx := rng.Intn(KEY_COUNT)
+	err = h.Insert(bKeys[ndx], []byte("something else"))
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(h.RootDigest(), full), Equals, false)
+	err = h.Insert(bKeys[ndx], &rawKeys[ndx])
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(h.RootDigest(), full), Equals, true)
+
+	// the same entries inserted in a different order give the same digest
+	h2, err := NewMerkleHAMT(w, t)
+	c.Assert(err, IsNil)
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT; i++ {
+		j := perm[i]
+		err = h2.Insert(bKeys[j], rawKeys[j]) // []byte rather than *[]byte
+		c.Assert(err, IsNil)
+	}
+	c.Assert(bytes.Equal(h2.RootDigest(), full), Equals, true)
+
+	// deleting in reverse order retraces the sequence of digests
+	for i := KEY_COUNT - 1; i >= 0; i-- {
+		err = h.Delete(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(bytes.Equal(h.RootDigest(), digests[i]), Equals, true)
+	}
+	c.Assert(h.GetTableCount(), Equals, uint(1))
+}
+
+// ------------------------------------------------------------------
+
+// Deletions must leave the trie in canonical form, so that keys which
+// share long hashcode prefixes leave no tables behind when deleted.
+func (s *XLSuite) TestMerkleCanonicalDeletes(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MERKLE_CANONICAL_DELETES")
+	}
+	rng := xr.MakeSimpleRNG()
+	w := uint(5)
+	t := uint(5)
+	_, rawKeys := s.makePermutedKeys(rng, w)
+	KEY_COUNT := 64 / w
+
+	h, err := NewMerkleHAMT(w, t)
+	c.Assert(err, IsNil)
+	empty := h.RootDigest()
+
+	bKeys := make([]BytesKey, KEY_COUNT)
+	for i := uint(0); i < KEY_COUNT; i++ {
+		bKeys[i], err = NewBytesKey(rawKeys[i])
+		c.Assert(err, IsNil)
+		err = h.Insert(bKeys[i], rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	c.Assert(h.GetTableCount(), Equals, KEY_COUNT)
+
+	// delete the entries from the bottom up; the single key remaining
+	// must then be found in the root
+	h2, err := NewMerkleHAMT(w, t)
+	c.Assert(err, IsNil)
+	err = h2.Insert(bKeys[0], rawKeys[0])
+	c.Assert(err, IsNil)
+	for i := KEY_COUNT - 1; i > 0; i-- {
+		err = h.Delete(bKeys[i])
+		c.Assert(err, IsNil)
+	}
+	c.Assert(h.GetTableCount(), Equals, uint(1))
+	c.Assert(h.GetLeafCount(), Equals, uint(1))
+	c.Assert(bytes.Equal(h.RootDigest(), h2.RootDigest()), Equals, true)
+
+	err = h.Delete(bKeys[0])
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(h.RootDigest(), empty), Equals, true)
+}
@@ -0,0 +1,352 @@
+package hamt_go
+
+// hamt_go/options.go
+
+import (
+	"sync"
+)
+
+// New creates a HAMT configured by any number of Options, each setting
+// one property and checking its own parameters, so that
+//
+//	h, err := New(WithW(5), WithT(16), WithConcurrency(Synchronized))
+//
+// fails, if it does, with an error naming the first problem found.
+// Options which cannot be used together return IncompatibleOptions.
+// With no options, New creates a HAMT as NewHAMT(6, 0) does.
+
+// The settings made by Options, from which New builds a HAMT.
+type config struct {
+	schedule      []uint // widths by depth, in canonical form
+	t             uint   // if 0, defaults to the first width
+	haveWT        bool   // widths or t set explicitly
+	haveCapacity  bool   // widths and t chosen by WithCapacity
+	sparse        bool
+	merkle        bool
+	pageSize      uint
+	compressPaths bool
+	buckets       bool
+	hasher        func(KeyI) uint64
+	concurrency   ConcurrencyMode
+	maxEntries    uint
+	hook          MetricsHook
+	copyKeys      bool
+}
+
+// An Option sets one property of a HAMT created by New, returning an
+// error if its parameters are invalid.
+type Option func(*config) error
+
+// How a HAMT created by New handles keys whose hashcodes are the same.
+type CollisionPolicy int
+
+const (
+	CollisionsFail   CollisionPolicy = iota // MaxTableDepthExceeded
+	CollisionBuckets                        // kept together; see pages.go
+)
+
+// Whether a HAMT created by New may be used from several goroutines.
+type ConcurrencyMode int
+
+const (
+	Unsynchronized ConcurrencyMode = iota // the caller synchronizes
+	Synchronized                          // guarded by a sync.RWMutex
+)
+
+// Whether a HAMT created by New keeps the keys it is given or copies.
+type KeyOwnership int
+
+const (
+	KeysShared KeyOwnership = iota // the caller must not change a key
+	KeysCopied                     // the bytes of keys are copied
+)
+
+// The operations reported to a MetricsHook.
+type Op int
+
+const (
+	OpInsert Op = iota
+	OpDelete
+	OpFind
+)
+
+// Called after each Insert, Delete, Find, and FindString with the
+// operation and the error returned, or for a Find which finds nothing,
+// NotFound.
+type MetricsHook func(op Op, err error)
+
+// Give all Tables 2^w slots, as NewHAMT does.  w may be from 1 to 8.
+func WithW(w uint) Option {
+	return WithWidths(w)
+}
+
+// Give Tables widths which differ by depth, as NewHAMTWithWidths does.
+func WithWidths(widths ...uint) Option {
+	return func(c *config) (err error) {
+		c.schedule, err = normalizeWidths(widths)
+		c.haveWT = true
+		return
+	}
+}
+
+// Give the root 2^t slots.  t may be at most 64; if it is 0, it
+// defaults to w, or to the first of the widths.
+func WithT(t uint) Option {
+	return func(c *config) (err error) {
+		if t > 64 {
+			err = MaxRootTableSizeExceeded
+		} else {
+			c.t = t
+			c.haveWT = true
+		}
+		return
+	}
+}
+
+// Choose w and t for about n entries, as NewHAMTWithCapacity does.
+// This cannot be combined with WithW, WithWidths, or WithT.
+func WithCapacity(n uint, prefer Preference) Option {
+	return func(c *config) (err error) {
+		var w, t uint
+		w, t, err = chooseWT(n, prefer)
+		if err == nil {
+			c.schedule, c.t = []uint{w}, t
+			c.haveCapacity = true
+		}
+		return
+	}
+}
+
+// Compress the root's slots, as NewSparseHAMT does.
+func WithSparseRoot() Option {
+	return func(c *config) error {
+		c.sparse = true
+		return nil
+	}
+}
+
+// Maintain digests, as NewMerkleHAMT does.  A Merkle HAMT cannot be
+// sparse, have pages, compressed paths, or collision buckets, use a
+// hasher, or be Synchronized.  Nor can it have a limit on entries or a
+// metrics hook, as PullSync inserts and deletes entries without
+// counting them or calling the hook.
+func WithMerkle() Option {
+	return func(c *config) error {
+		c.merkle = true
+		return nil
+	}
+}
+
+// Keep up to k entries together in leaf pages, as NewHAMTWithPages does.
+func WithPages(k uint) Option {
+	return func(c *config) (err error) {
+		if k > maxPageSize {
+			err = MaxPageSizeExceeded
+		} else if k > 1 {
+			c.pageSize = k
+		}
+		return
+	}
+}
+
+// Let Tables skip levels, as NewHAMTWithCompressedPaths does.  This
+// cannot be combined with pages or collision buckets.
+func WithCompressedPaths() Option {
+	return func(c *config) error {
+		c.compressPaths = true
+		return nil
+	}
+}
+
+// Set how keys with the same hashcode are handled.
+func WithCollisions(policy CollisionPolicy) Option {
+	return func(c *config) (err error) {
+		switch policy {
+		case CollisionsFail, CollisionBuckets:
+			c.buckets = policy == CollisionBuckets
+		default:
+			err = UnknownCollisionPolicy
+		}
+		return
+	}
+}
+
+// Place keys in the trie by hasher(key) rather than key.Hashcode(),
+// for keys whose own hashcodes are poorly distributed.  The hasher must
+// return the same value for keys which are equal.  FindString then
+// allocates.
+func WithHasher(hasher func(KeyI) uint64) Option {
+	return func(c *config) (err error) {
+		if hasher == nil {
+			err = NilHasher
+		} else {
+			c.hasher = hasher
+		}
+		return
+	}
+}
+
+// Set whether the HAMT guards itself against concurrent use.  A
+// Synchronized HAMT takes a write lock in Insert and Delete and a read
+// lock in Find, FindString, and the methods which walk the trie, such
+// as Stats and Freeze.
+func WithConcurrency(mode ConcurrencyMode) Option {
+	return func(c *config) (err error) {
+		switch mode {
+		case Unsynchronized, Synchronized:
+			c.concurrency = mode
+		default:
+			err = UnknownConcurrencyMode
+		}
+		return
+	}
+}
+
+// Allow at most n entries: inserting a new key into a HAMT which holds
+// n returns MaxEntriesExceeded.  0, the default, means no limit.  With
+// a limit, Insert looks the key up before inserting it.
+func WithMaxEntries(n uint) Option {
+	return func(c *config) error {
+		c.maxEntries = n
+		return nil
+	}
+}
+
+// Call hook after each Insert, Delete, Find, and FindString.  It is
+// called while any lock is held, so must not call back into the HAMT.
+func WithMetrics(hook MetricsHook) Option {
+	return func(c *config) (err error) {
+		if hook == nil {
+			err = NilMetricsHook
+		} else {
+			c.hook = hook
+		}
+		return
+	}
+}
+
+// Set whether Insert keeps the keys it is given or copies them.  With
+// KeysCopied, a BytesKey's bytes, including those of any BytesKey in a
+// TupleKey, are copied, so the caller may reuse its buffers; keys of
+// other types in this package cannot change.
+func WithKeyOwnership(policy KeyOwnership) Option {
+	return func(c *config) (err error) {
+		switch policy {
+		case KeysShared, KeysCopied:
+			c.copyKeys = policy == KeysCopied
+		default:
+			err = UnknownKeyOwnership
+		}
+		return
+	}
+}
+
+// Return IncompatibleOptions if the settings cannot be used together.
+func (c *config) check() (err error) {
+	if c.haveCapacity && c.haveWT ||
+		c.merkle && (c.sparse || c.pageSize > 1 || c.compressPaths ||
+			c.buckets || c.hasher != nil || c.concurrency == Synchronized ||
+			c.maxEntries > 0 || c.hook != nil) ||
+		c.compressPaths && (c.pageSize > 1 || c.buckets) {
+
+		err = IncompatibleOptions
+	}
+	return
+}
+
+// Create a new HAMT configured by opts; see above.  nil Options are
+// ignored.
+func New(opts ...Option) (h HAMT, err error) {
+	c := &config{schedule: []uint{6}}
+	for i := 0; err == nil && i < len(opts); i++ {
+		if opts[i] != nil {
+			err = opts[i](c)
+		}
+	}
+	if err == nil {
+		err = c.check()
+	}
+	if err == nil {
+		t := c.t
+		if t == 0 {
+			t = c.schedule[0]
+		}
+		var root *Root
+		root, err = newRootWithWidths(c.schedule, t, c.sparse)
+		if err == nil {
+			if c.merkle {
+				root.initMerkle()
+			}
+			root.pageSize = c.pageSize
+			root.compressPaths = c.compressPaths
+			root.buckets = c.buckets
+			root.hasher = c.hasher
+			if c.concurrency == Synchronized {
+				root.lock = new(sync.RWMutex)
+			}
+			root.maxEntries = c.maxEntries
+			root.hook = c.hook
+			root.copyKeys = c.copyKeys
+			h = HAMT{root: root}
+		}
+	}
+	return
+}
+
+// In a Synchronized HAMT, take and release the read lock; otherwise
+// do nothing.
+func (root *Root) readLock() {
+	if root.lock != nil {
+		root.lock.RLock()
+	}
+}
+
+func (root *Root) readUnlock() {
+	if root.lock != nil {
+		root.lock.RUnlock()
+	}
+}
+
+// In a Synchronized HAMT, take and release the write lock; otherwise
+// do nothing.
+func (root *Root) writeLock() {
+	if root.lock != nil {
+		root.lock.Lock()
+	}
+}
+
+func (root *Root) writeUnlock() {
+	if root.lock != nil {
+		root.lock.Unlock()
+	}
+}
+
+// Find, for a HAMT which is Synchronized, has a MetricsHook, or uses a
+// hasher: the plain path is kept free of all three.
+func (root *Root) findObserved(key KeyI) (value interface{}, err error) {
+	root.readLock()
+	defer root.readUnlock()
+	value, err = root.findLeaf(key)
+	if root.hook != nil {
+		hookErr := err
+		if err == nil && value == nil {
+			hookErr = NotFound
+		}
+		root.hook(OpFind, hookErr)
+	}
+	return
+}
+
+// With a limit on the number of entries, called before inserting key:
+// return whether the key is new, or MaxEntriesExceeded if it is and
+// the HAMT is full.
+func (root *Root) admit(key KeyI) (isNew bool, err error) {
+	if value, _ := root.findLeaf(key); value == nil {
+		if root.entries >= root.maxEntries {
+			err = MaxEntriesExceeded
+		} else {
+			isNew = true
+		}
+	}
+	return
+}
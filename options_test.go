@@ -0,0 +1,369 @@
+package hamt_go
+
+// hamt_go/options_test.go
+
+import (
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"sync"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestNewOptions(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_NEW_OPTIONS")
+	}
+	// each option checks its own parameters
+	bad := []struct {
+		opt Option
+		err error
+	}{
+		{WithW(0), ZeroLengthTables},
+		{WithW(9), MaxTableSizeExceeded},
+		{WithWidths(), ZeroLengthTables},
+		{WithWidths(6, 0), ZeroLengthTables},
+		{WithT(65), MaxRootTableSizeExceeded},
+		{WithCapacity(1000, -1), UnknownPreference},
+		{WithPages(65), MaxPageSizeExceeded},
+		{WithCollisions(CollisionBuckets + 1), UnknownCollisionPolicy},
+		{WithHasher(nil), NilHasher},
+		{WithConcurrency(Synchronized + 1), UnknownConcurrencyMode},
+		{WithMetrics(nil), NilMetricsHook},
+		{WithKeyOwnership(KeysCopied + 1), UnknownKeyOwnership},
+	}
+	for _, tc := range bad {
+		_, err := New(tc.opt)
+		c.Assert(err, Equals, tc.err)
+	}
+	// and some cannot be used together
+	hasher := func(k KeyI) uint64 { return k.Hashcode() }
+	incompatible := [][]Option{
+		{WithCapacity(1000, Balanced), WithW(5)},
+		{WithT(8), WithCapacity(1000, Balanced)},
+		{WithMerkle(), WithSparseRoot()},
+		{WithMerkle(), WithPages(4)},
+		{WithMerkle(), WithCompressedPaths()},
+		{WithMerkle(), WithCollisions(CollisionBuckets)},
+		{WithMerkle(), WithHasher(hasher)},
+		{WithMerkle(), WithConcurrency(Synchronized)},
+		{WithMerkle(), WithMaxEntries(10)},
+		{WithMerkle(), WithMetrics(func(Op, error) {})},
+		{WithCompressedPaths(), WithPages(4)},
+		{WithCompressedPaths(), WithCollisions(CollisionBuckets)},
+	}
+	for _, opts := range incompatible {
+		_, err := New(opts...)
+		c.Assert(err, Equals, IncompatibleOptions)
+	}
+
+	// the defaults are those of NewHAMT(6, 0), and nil options are ignored
+	h, err := New(nil)
+	c.Assert(err, IsNil)
+	c.Assert(h.GetW(), Equals, uint(6))
+	c.Assert(h.GetT(), Equals, uint(6))
+	h, err = New(WithWidths(6, 5, 4), WithT(10), WithSparseRoot(), WithPages(8))
+	c.Assert(err, IsNil)
+	c.Assert(h.GetWidths(), DeepEquals, []uint{6, 5, 4})
+	c.Assert(h.GetT(), Equals, uint(10))
+	c.Assert(h.IsSparse(), Equals, true)
+	c.Assert(h.GetPageSize(), Equals, uint(8))
+	h, err = New(WithCapacity(10000, PreferMemory), WithMerkle())
+	c.Assert(err, IsNil)
+	c.Assert(h.GetT(), Equals, uint(9))
+	c.Assert(h.IsMerkle(), Equals, true)
+
+	// NewHAMT is a shim over New, failing as it did
+	_, err = NewHAMT(0, 0)
+	c.Assert(err, Equals, ZeroLengthTables)
+	_, err = NewHAMT(9, 4)
+	c.Assert(err, Equals, MaxTableSizeExceeded)
+	_, err = NewHAMT(5, 65)
+	c.Assert(err, Equals, MaxRootTableSizeExceeded)
+	h, err = NewHAMT(0, 4)
+	c.Assert(err, Equals, ZeroLengthTables)
+	h, err = NewHAMT(5, 0)
+	c.Assert(err, IsNil)
+	c.Assert(h.GetW(), Equals, uint(5))
+	c.Assert(h.GetT(), Equals, uint(5))
+}
+
+func (s *XLSuite) TestCollisionBuckets(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_COLLISION_BUCKETS")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestCollisionBuckets(c, rng, nil)
+	s.doTestCollisionBuckets(c, rng, WithPages(4))
+	s.doTestCollisionBuckets(c, rng, WithWidths(6, 4, 2))
+}
+
+func (s *XLSuite) doTestCollisionBuckets(c *C, rng *xr.PRNG, opt Option) {
+	// groups of keys with the same hashcode, and some which differ
+	// from a group only in their last bits
+	var keys []hc32Key
+	for g := uint64(0); g < 8; g++ {
+		hc := uint64(rng.Int63())
+		for i := 0; i < 5; i++ {
+			keys = append(keys, hc32Key{len(keys), hc})
+		}
+		keys = append(keys, hc32Key{len(keys), hc ^ 1<<63})
+	}
+	plain, err := New(opt)
+	c.Assert(err, IsNil)
+	// without buckets, five keys with the same hashcode cannot all be
+	// inserted, though with pages some may share one
+	for i := 0; i < 5 && err == nil; i++ {
+		err = plain.Insert(keys[i], i)
+	}
+	c.Assert(err, Equals, MaxTableDepthExceeded)
+
+	h, err := New(opt, WithCollisions(CollisionBuckets))
+	c.Assert(err, IsNil)
+	for i := 0; i < len(keys); i++ {
+		c.Assert(h.Insert(keys[i], i), IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(h.GetLeafCount(), Equals, uint(len(keys)))
+	for i := 0; i < len(keys); i++ {
+		value, err := h.Find(keys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+	value, err := h.Find(hc32Key{len(keys), keys[0].hc})
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+	_, err = h.Freeze()
+	c.Assert(err, Equals, PagesUnsupported)
+
+	perm := rng.Perm(len(keys))
+	for i := 0; i < len(keys); i++ {
+		c.Assert(h.Delete(keys[perm[i]]), IsNil)
+		c.Assert(h.Delete(keys[perm[i]]), Equals, NotFound)
+		c.Assert(h.Validate(), HasLen, 0)
+		for j := i + 1; j < len(keys); j++ {
+			value, err := h.Find(keys[perm[j]])
+			c.Assert(err, IsNil)
+			c.Assert(value, Equals, perm[j])
+		}
+	}
+	c.Assert(h.GetLeafCount(), Equals, uint(0))
+	c.Assert(h.GetTableCount(), Equals, uint(1))
+}
+
+func (s *XLSuite) TestWithHasher(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_WITH_HASHER")
+	}
+	// keys whose own hashcodes are all the same, told apart by a hasher
+	hasher := func(k KeyI) uint64 {
+		return mix64(uint64(k.(hc32Key).n))
+	}
+	h, err := New(WithHasher(hasher), WithW(5))
+	c.Assert(err, IsNil)
+	const KEY_COUNT = 1024
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Insert(hc32Key{i, 42}, i), IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := h.Find(hc32Key{i, 42})
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+	_, err = h.Freeze()
+	c.Assert(err, Equals, HasherUnsupported)
+	for i := 0; i < KEY_COUNT; i += 2 {
+		c.Assert(h.Delete(hc32Key{i, 42}), IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT/2))
+
+	// FindString hashes StringKeys with the hasher too
+	h, err = New(WithHasher(func(k KeyI) uint64 { return ^k.Hashcode() }))
+	c.Assert(err, IsNil)
+	c.Assert(h.Insert(StringKey("abc"), 1), IsNil)
+	value, err := h.FindString("abc")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, 1)
+}
+
+func (s *XLSuite) TestSynchronized(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SYNCHRONIZED")
+	}
+	const (
+		WRITERS   = 4
+		KEY_COUNT = 2048
+	)
+	rawKeys, bKeys := makeSomeUniqueKeys(WRITERS*KEY_COUNT, 16)
+	h, err := New(WithConcurrency(Synchronized))
+	c.Assert(err, IsNil)
+
+	// writers insert and delete their own keys while readers find
+	// those of the others
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*WRITERS)
+	for g := 0; g < WRITERS; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := g * KEY_COUNT; i < (g+1)*KEY_COUNT; i++ {
+				if err := h.Insert(bKeys[i], &rawKeys[i]); err != nil {
+					errs <- err
+					return
+				}
+				if i%2 == 1 {
+					if err := h.Delete(bKeys[i-1]); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(g)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < WRITERS*KEY_COUNT; i++ {
+				value, err := h.Find(bKeys[i])
+				if err != nil {
+					errs <- err
+					return
+				} else if value != nil && value != &rawKeys[i] {
+					errs <- fmt.Errorf("key %d has the wrong value", i)
+					return
+				}
+			}
+			h.Stats()
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(h.GetLeafCount(), Equals, uint(WRITERS*KEY_COUNT/2))
+}
+
+func (s *XLSuite) TestMaxEntries(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MAX_ENTRIES")
+	}
+	_, bKeys := makeSomeUniqueKeys(12, 16)
+	h, err := New(WithMaxEntries(10))
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		c.Assert(h.Insert(bKeys[i], i), IsNil)
+	}
+	// a full HAMT refuses new keys, but may replace values
+	c.Assert(h.Insert(bKeys[10], 10), Equals, MaxEntriesExceeded)
+	c.Assert(h.Insert(bKeys[0], "x"), IsNil)
+	value, err := h.Find(bKeys[10])
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	// deleting makes room, failed deletes do not
+	c.Assert(h.Delete(bKeys[11]), Equals, NotFound)
+	c.Assert(h.Insert(bKeys[11], 11), Equals, MaxEntriesExceeded)
+	c.Assert(h.Delete(bKeys[0]), IsNil)
+	c.Assert(h.Insert(bKeys[10], 10), IsNil)
+	c.Assert(h.Insert(bKeys[11], 11), Equals, MaxEntriesExceeded)
+	c.Assert(h.GetLeafCount(), Equals, uint(10))
+}
+
+func (s *XLSuite) TestMetricsHook(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_METRICS_HOOK")
+	}
+	counts := make(map[Op]map[error]int)
+	hook := func(op Op, err error) {
+		if counts[op] == nil {
+			counts[op] = make(map[error]int)
+		}
+		counts[op][err]++
+	}
+	_, bKeys := makeSomeUniqueKeys(8, 16)
+	h, err := New(WithMetrics(hook), WithMaxEntries(6))
+	c.Assert(err, IsNil)
+	for i := 0; i < 8; i++ {
+		h.Insert(bKeys[i], i)
+	}
+	c.Assert(h.Insert(nil, 0), Equals, NilKey)
+	for i := 0; i < 8; i++ {
+		h.Find(bKeys[i])
+	}
+	h.FindString("abc")
+	for i := 0; i < 3; i++ {
+		h.Delete(bKeys[7-i])
+	}
+	c.Assert(counts[OpInsert], DeepEquals,
+		map[error]int{nil: 6, MaxEntriesExceeded: 2, NilKey: 1})
+	c.Assert(counts[OpFind], DeepEquals, map[error]int{nil: 6, NotFound: 3})
+	c.Assert(counts[OpDelete], DeepEquals, map[error]int{nil: 1, NotFound: 2})
+}
+
+func (s *XLSuite) TestMerkleWithLimits(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MERKLE_WITH_LIMITS")
+	}
+	// PullSync would bypass a limit on entries and a metrics hook, so a
+	// Merkle HAMT can have neither
+	hook := func(op Op, err error) {}
+	_, err := New(WithMerkle(), WithMaxEntries(4))
+	c.Assert(err, Equals, IncompatibleOptions)
+	_, err = New(WithMerkle(), WithMetrics(hook))
+	c.Assert(err, Equals, IncompatibleOptions)
+	_, err = New(WithMaxEntries(4), WithMetrics(hook), WithMerkle())
+	c.Assert(err, Equals, IncompatibleOptions)
+
+	// without them, a Merkle HAMT from New pulls any number of entries
+	const KEY_COUNT = 64
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+	server, err := New(WithMerkle())
+	c.Assert(err, IsNil)
+	client, err := New(WithMerkle())
+	c.Assert(err, IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(server.Insert(bKeys[i], rawKeys[i]), IsNil)
+	}
+	changes, _, err := s.doSync(c, server, client)
+	c.Assert(err, IsNil)
+	c.Assert(changes, Equals, uint(KEY_COUNT))
+	c.Assert(client.GetLeafCount(), Equals, uint(KEY_COUNT))
+	c.Assert(client.RootDigest(), DeepEquals, server.RootDigest())
+}
+
+func (s *XLSuite) TestKeyOwnership(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_KEY_OWNERSHIP")
+	}
+	buf := []byte("0123456789abcdef")
+	shared, err := New(WithKeyOwnership(KeysShared))
+	c.Assert(err, IsNil)
+	copied, err := New(WithKeyOwnership(KeysCopied))
+	c.Assert(err, IsNil)
+	tuple, err := NewTupleKey(BytesKey{buf}, Uint64Key(7))
+	c.Assert(err, IsNil)
+	for _, h := range []HAMT{shared, copied} {
+		c.Assert(h.Insert(BytesKey{buf}, 1), IsNil)
+		c.Assert(h.Insert(tuple, 2), IsNil)
+	}
+	// reusing the caller's buffer corrupts only the HAMT sharing it
+	orig := append([]byte(nil), buf...)
+	copy(buf, "fedcba9876543210")
+	origTuple, err := NewTupleKey(BytesKey{orig}, Uint64Key(7))
+	c.Assert(err, IsNil)
+
+	value, err := copied.Find(BytesKey{orig})
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, 1)
+	value, err = copied.Find(origTuple)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, 2)
+	c.Assert(copied.Validate(), HasLen, 0)
+
+	value, err = shared.Find(BytesKey{orig})
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+}
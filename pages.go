@@ -0,0 +1,153 @@
+package hamt_go
+
+// hamt_go/pages.go
+
+// Where a few keys share a long hashcode prefix, a HAMT builds a chain
+// of Tables, one per shared chunk of the hashcode, each with a single
+// slot in use, down to the depth at which the keys' hashcodes differ.
+// A HAMT created by NewHAMTWithPages instead keeps any group of up to
+// k entries below a slot together in a leaf page, a Table with no
+// bitmaps whose entries are in no particular order and are searched in
+// turn.  Only when a page overflows does it burst into an ordinary
+// Table, its entries spreading over the Table's slots and any which
+// still share a slot going into a page below.  Likewise, when deletions
+// leave no more than k entries below a Table, they are gathered back
+// into a page.  A trie with pages is then in canonical form just as one
+// without: every Table below the root holds more than k entries, and
+// every page from 2 to k.
+//
+// Pages are counted neither by GetTableCount nor in Stats.TableCount,
+// but in Stats.PageCount.  A HAMT with pages is never a Merkle HAMT and
+// cannot be frozen.
+//
+// Keys whose hashcodes are the same cannot be told apart by any Table,
+// and inserting a second such key ordinarily fails with
+// MaxTableDepthExceeded.  A HAMT created by New with the option
+// WithCollisions(CollisionBuckets) instead keeps them in a collision
+// bucket, a page one deeper than the deepest Tables, which holds any
+// number of entries and never bursts.  Buckets are counted as pages.
+
+const maxPageSize = 64
+
+// Return whether the table is a leaf page.  An ordinary Table holding
+// anything has a bit set in its bitmaps, or if w > 6 has wideMaps.
+func (table *Table) isPage() bool {
+	return table.dataMap|table.nodeMap == 0 && table.wide == nil &&
+		len(table.values) > 0 && (table.root.pageSize > 1 || table.root.buckets)
+}
+
+// Create an empty leaf page, for the entries below a slot in a table
+// at depth-1.  Like a Table created to hold entries, a page starts out
+// with room for two.  Its mask is zero, so that a lookup passing
+// through it finds slot 0 empty, whatever the width, and goes on to
+// search its entries.  A collision bucket takes the width of the
+// deepest Tables.
+func newPage(depth uint, root *Root) *Table {
+	if depth > root.maxTableDepth {
+		depth = root.maxTableDepth
+	}
+	st := new(smallTable)
+	page := &st.Table
+	page.w = uint8(root.widths[depth])
+	page.root = root
+	page.keys = st.keyBuf[:0]
+	page.values = st.valueBuf[:0]
+	return page
+}
+
+// Return the value in the page associated with key, or nil if there is
+// none.
+func (page *Table) findInPage(key KeyI) (value interface{}) {
+	for i := 0; i < len(page.keys); i++ {
+		if sameKey(page.keys[i], key) {
+			return page.values[i]
+		}
+	}
+	return
+}
+
+// Insert an entry into a page at the depth given, replacing the value
+// of any entry with the same key, and bursting the page if it then
+// holds too many entries.  A collision bucket never bursts.
+func (page *Table) insertIntoPage(depth uint, key KeyI, value interface{}) (
+	err error) {
+
+	for i := 0; i < len(page.keys); i++ {
+		if sameKey(page.keys[i], key) {
+			page.values[i] = value
+			return
+		}
+	}
+	n := uint(len(page.values))
+	if n == uint(cap(page.values)) {
+		page.growEntries()
+	}
+	page.keys = insertAt(page.keys, n, key)
+	page.values = insertAt(page.values, n, value)
+	if n+1 > page.root.pageSize && depth <= page.root.maxTableDepth {
+		err = page.burst(depth)
+		if err != nil {
+			// the entries cannot be told apart; leave the page as it was
+			page.keys = removeAt(page.keys, n)
+			page.values = removeAt(page.values, n)
+		}
+	}
+	return
+}
+
+// Turn a page at the depth given into an ordinary Table holding the same
+// entries.  This fails with MaxTableDepthExceeded if two of them have
+// the same hashcode; the page is then unchanged.
+func (page *Table) burst(depth uint) (err error) {
+	root := page.root
+	table, err := newTable(depth, root, false)
+	for i := 0; err == nil && i < len(page.values); i++ {
+		key := page.keys[i]
+		err = table.insertLeaf(root.hash(key)>>root.shifts[depth], depth,
+			key, page.values[i], nil)
+	}
+	if err == nil {
+		*page = *table
+	}
+	return
+}
+
+// Return the number of entries in the table and those below it, or
+// any number greater than limit if there are more than limit.
+func (table *Table) countUpTo(limit uint) (count uint) {
+	count = uint(len(table.values))
+	for i := 0; i < len(table.nodes) && count <= limit; i++ {
+		count += table.nodes[i].countUpTo(limit - count)
+	}
+	return
+}
+
+// Append the entries in the table and those below it to page.
+func (table *Table) gatherInto(page *Table) {
+	for i := 0; i < len(table.values); i++ {
+		n := uint(len(page.values))
+		if n == uint(cap(page.values)) {
+			page.growEntries()
+		}
+		page.keys = insertAt(page.keys, n, table.keys[i])
+		page.values = insertAt(page.values, n, table.values[i])
+	}
+	for i := 0; i < len(table.nodes); i++ {
+		table.nodes[i].gatherInto(page)
+	}
+}
+
+// Called after a deletion below table, an ordinary Table at the depth
+// given: in a HAMT with pages, if no more than a page's worth of
+// entries remain below it, return a page holding them to replace it.
+// Otherwise return nil.
+func (table *Table) pageIfSmall(depth uint) (page *Table) {
+	root := table.root
+	if root.pageSize > 1 && !table.isPage() &&
+		table.countUpTo(root.pageSize) <= root.pageSize {
+
+		page = newPage(depth, root)
+		table.gatherInto(page)
+	}
+	return
+}
@@ -0,0 +1,154 @@
+package hamt_go
+
+// hamt_go/pages_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestPagesCtor(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_PAGES_CTOR")
+	}
+	_, err := NewHAMTWithPages(5, 5, maxPageSize+1)
+	c.Assert(err, Equals, MaxPageSizeExceeded)
+	_, err = NewHAMTWithPages(0, 0, 4)
+	c.Assert(err, Equals, ZeroLengthTables)
+	h, err := NewHAMTWithPages(5, 5, 1)
+	c.Assert(err, IsNil)
+	c.Assert(h.GetPageSize(), Equals, uint(0))
+	h, err = NewHAMTWithPages(5, 5, 8)
+	c.Assert(err, IsNil)
+	c.Assert(h.GetPageSize(), Equals, uint(8))
+	_, err = h.Freeze()
+	c.Assert(err, Equals, PagesUnsupported)
+}
+
+func (s *XLSuite) TestPages(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_PAGES")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestPages(c, rng, 5, 4, 2)
+	s.doTestPages(c, rng, 6, 8, 8)
+	s.doTestPages(c, rng, 8, 6, 16)
+	s.doTestPages(c, rng, 4, 4, maxPageSize)
+}
+
+func (s *XLSuite) doTestPages(c *C, rng *xr.PRNG, w, t, k uint) {
+	const KEY_COUNT = 4096
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	h, err := NewHAMTWithPages(w, t, k)
+	c.Assert(err, IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT))
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := h.Find(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, &rawKeys[i])
+	}
+	// replacing a value in a page changes nothing else
+	c.Assert(h.Insert(bKeys[0], "x"), IsNil)
+	value, err := h.Find(bKeys[0])
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "x")
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT))
+
+	st := h.Stats()
+	c.Assert(st.PageCount > 0, Equals, true)
+	c.Assert(st.TableCount, Equals, h.GetTableCount())
+	c.Assert(st.LeafCount, Equals, uint(KEY_COUNT))
+
+	// pages show up in dumps
+	var b bytes.Buffer
+	c.Assert(h.WriteText(&b, nil), IsNil)
+	c.Assert(strings.Contains(b.String(), "page depth"), Equals, true)
+
+	// deleting the keys gathers what remains back into pages, the trie
+	// staying in canonical form throughout
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Delete(bKeys[perm[i]]), IsNil)
+		c.Assert(h.Delete(bKeys[perm[i]]), Equals, NotFound)
+		if i%512 == 0 {
+			c.Assert(h.Validate(), HasLen, 0)
+		}
+	}
+	c.Assert(h.GetLeafCount(), Equals, uint(0))
+	c.Assert(h.GetTableCount(), Equals, uint(1))
+	c.Assert(h.Stats().PageCount, Equals, uint(0))
+}
+
+func (s *XLSuite) TestPagesForClusteredKeys(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_PAGES_FOR_CLUSTERED_KEYS")
+	}
+	// groups of keys whose hashcodes share their low 48 bits
+	var keys []hc32Key
+	for g := uint64(0); g < 16; g++ {
+		for i := uint64(0); i < 4; i++ {
+			keys = append(keys, hc32Key{len(keys), i<<48 | g*0x1234567})
+		}
+	}
+	h, err := NewHAMT(4, 4)
+	c.Assert(err, IsNil)
+	p, err := NewHAMTWithPages(4, 4, 4)
+	c.Assert(err, IsNil)
+	for i := 0; i < len(keys); i++ {
+		c.Assert(h.Insert(keys[i], i), IsNil)
+		c.Assert(p.Insert(keys[i], i), IsNil)
+	}
+	c.Assert(p.Validate(), HasLen, 0)
+	for i := 0; i < len(keys); i++ {
+		value, err := p.Find(keys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+	// without pages each group makes a chain of tables down to the
+	// 49th bit; with them, each group is one page
+	c.Assert(h.GetTableCount() > 16*10, Equals, true)
+	c.Assert(p.GetTableCount() <= 1+16, Equals, true)
+	c.Assert(p.Stats().PageCount, Equals, uint(16))
+	c.Assert(p.Stats().Bytes < h.Stats().Bytes/4, Equals, true)
+
+	// a fifth key in a group bursts its page, into a chain of tables
+	// ending in one holding all five
+	tableCount := p.GetTableCount()
+	extra := hc32Key{len(keys), 4<<48 | 3*0x1234567}
+	c.Assert(p.Insert(extra, "x"), IsNil)
+	c.Assert(p.Validate(), HasLen, 0)
+	c.Assert(p.Stats().PageCount, Equals, uint(15))
+	c.Assert(p.GetTableCount(), Equals, tableCount+12)
+	value, err := p.Find(extra)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "x")
+	// and deleting it gathers the group back into a page
+	c.Assert(p.Delete(extra), IsNil)
+	c.Assert(p.Validate(), HasLen, 0)
+	c.Assert(p.Stats().PageCount, Equals, uint(16))
+
+	// keys with the same hashcode share a page until it overflows
+	q, err := NewHAMTWithPages(4, 4, 2)
+	c.Assert(err, IsNil)
+	for i := 0; i < 2; i++ {
+		c.Assert(q.Insert(hc32Key{i, 42}, i), IsNil)
+	}
+	c.Assert(q.Insert(hc32Key{2, 42}, 2), Equals, MaxTableDepthExceeded)
+	c.Assert(q.Validate(), HasLen, 0)
+	c.Assert(q.GetLeafCount(), Equals, uint(2))
+	for i := 0; i < 2; i++ {
+		value, err := q.Find(hc32Key{i, 42})
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+}
@@ -0,0 +1,122 @@
+package hamt_go
+
+// hamt_go/paths.go
+
+import (
+	"math/bits"
+)
+
+// Where the hashcodes of the keys below a slot agree in several
+// successive chunks, a HAMT builds a chain of Tables, one per chunk,
+// each with a single slot in use, down to the depth at which they
+// differ.  A HAMT created by NewHAMTWithCompressedPaths instead
+// compresses such a chain into its last Table, which records how many
+// levels it skips and the value of the hashcode bits those levels use,
+// much as a node in a PATRICIA trie records the bits it skips.  A
+// lookup reaching such a Table checks the bits, failing at once if
+// they differ, and otherwise goes straight on at the Table's own depth.
+// An insert whose hashcode differs from them splits the path at the
+// first level at which it does, with a new Table there holding the new
+// entry and the old Table, now skipping fewer levels.  When deletions
+// leave a Table holding nothing but a single subtable, the two are
+// joined back into one path.  A trie with compressed paths is then in
+// canonical form just as one without: every Table below the root has at
+// least two slots in use.
+//
+// A Table's depth is that of the level whose hashcode chunk indexes its
+// slots, below any levels it skips, and it is this depth which Stats,
+// dumps, and violations report.  A HAMT with compressed paths is never
+// a Merkle HAMT and cannot be frozen.
+
+// Create a table holding a single entry, at the first level at or below
+// the depth given at which the entry's hashcode differs from hc, and
+// skipping any levels above that.  hc is the hashcode of another key,
+// shifted for the depth given, which the caller will insert into the
+// table.  This fails with MaxTableDepthExceeded if the two hashcodes are
+// the same.
+func newPathTable(depth uint, root *Root, hc uint64, key KeyI,
+	value interface{}) (table *Table, err error) {
+
+	oldHC := root.hash(key) >> root.shifts[depth]
+	d := depth
+	for d <= root.maxTableDepth && (hc^oldHC)>>(root.shifts[d]-root.shifts[depth])&
+		(uint64(1)<<root.widths[d]-1) == 0 {
+		d++
+	}
+	if d > root.maxTableDepth {
+		err = MaxTableDepthExceeded
+	} else {
+		table, err = newTableWithEntry(d, root, key, value, nil)
+		if err == nil && d > depth {
+			table.skip = uint8(d - depth)
+			table.skipBits = uint8(root.shifts[d] - root.shifts[depth])
+			table.prefix = hc & (uint64(1)<<table.skipBits - 1)
+		}
+	}
+	return
+}
+
+// Called when an insert reaches a table at the depth given whose path
+// the key's hashcode, hc, shifted for that depth, does not match.  The
+// table is split at the first level at which they differ: it becomes a
+// table at that level holding the new entry and, in another slot, a
+// copy of itself skipping only the levels below.
+func (table *Table) splitPath(hc uint64, depth uint, key KeyI,
+	value interface{}) (err error) {
+
+	root := table.root
+	d := depth
+	for (hc^table.prefix)>>(root.shifts[d]-root.shifts[depth])&
+		(uint64(1)<<root.widths[d]-1) == 0 {
+		d++
+	}
+	top, err := newTable(d, root, true)
+	if err == nil {
+		// The copy keeps any entries or wideMaps allocated along with the
+		// table; the table itself takes top's.
+		below := new(Table)
+		*below = *table
+		used := root.shifts[d+1] - root.shifts[depth]
+		below.skip -= uint8(d + 1 - depth)
+		below.skipBits -= uint8(used)
+		below.prefix >>= used
+
+		shift := root.shifts[d] - root.shifts[depth]
+		if d > depth {
+			top.skip = uint8(d - depth)
+			top.skipBits = uint8(shift)
+			top.prefix = table.prefix & (uint64(1)<<shift - 1)
+		}
+		top.setNode(table.prefix >> shift & uint64(top.mask))
+		top.nodes = append(top.nodes, below)
+		top.setData(hc >> shift & uint64(top.mask))
+		top.insertEntry(0, key, value, nil)
+		*table = *top
+	}
+	return
+}
+
+// Called after a deletion below table: in a HAMT with compressed paths,
+// if the table is left holding nothing but a single subtable, return
+// that subtable, its path extended to cover the table's, to replace it.
+// Otherwise return nil.
+func (table *Table) joinPath() (child *Table) {
+	if table.root.compressPaths && len(table.values) == 0 &&
+		len(table.nodes) == 1 {
+
+		_, nodeMap, n := table.bitmaps()
+		var ndx uint64
+		for i := 0; i < n; i++ {
+			if nodeMap[i] != 0 {
+				ndx = uint64(64*i + bits.TrailingZeros64(nodeMap[i]))
+			}
+		}
+		used := table.skipBits + table.w
+		child = table.nodes[0]
+		child.prefix = table.prefix | ndx<<table.skipBits |
+			child.prefix<<used
+		child.skipBits += used
+		child.skip += table.skip + 1
+	}
+	return
+}
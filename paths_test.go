@@ -0,0 +1,156 @@
+package hamt_go
+
+// hamt_go/paths_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestCompressedPathsCtor(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_COMPRESSED_PATHS_CTOR")
+	}
+	_, err := NewHAMTWithCompressedPaths(0, 0)
+	c.Assert(err, Equals, ZeroLengthTables)
+	h, err := NewHAMT(5, 5)
+	c.Assert(err, IsNil)
+	c.Assert(h.HasCompressedPaths(), Equals, false)
+	h, err = NewHAMTWithCompressedPaths(5, 5)
+	c.Assert(err, IsNil)
+	c.Assert(h.HasCompressedPaths(), Equals, true)
+	_, err = h.Freeze()
+	c.Assert(err, Equals, PathsUnsupported)
+}
+
+func (s *XLSuite) TestCompressedPaths(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_COMPRESSED_PATHS")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestCompressedPaths(c, rng, 1, 1)
+	s.doTestCompressedPaths(c, rng, 5, 4)
+	s.doTestCompressedPaths(c, rng, 6, 8)
+	s.doTestCompressedPaths(c, rng, 8, 6)
+}
+
+func (s *XLSuite) doTestCompressedPaths(c *C, rng *xr.PRNG, w, t uint) {
+	const KEY_COUNT = 4096
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	h, err := NewHAMTWithCompressedPaths(w, t)
+	c.Assert(err, IsNil)
+	plain, err := NewHAMT(w, t)
+	c.Assert(err, IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+		c.Assert(plain.Insert(bKeys[i], &rawKeys[i]), IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT))
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := h.Find(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, &rawKeys[i])
+	}
+	// replacing a value changes nothing else
+	c.Assert(h.Insert(bKeys[0], "x"), IsNil)
+	value, err := h.Find(bKeys[0])
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "x")
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT))
+
+	// the same leaves at the same depths, in fewer tables
+	st, plainSt := h.Stats(), plain.Stats()
+	c.Assert(st.TableCount, Equals, h.GetTableCount())
+	c.Assert(st.LeavesAtDepth, DeepEquals, plainSt.LeavesAtDepth)
+	c.Assert(st.TableCount+st.SkippedLevels, Equals, plainSt.TableCount)
+
+	// deleting the keys joins paths back together, the trie staying in
+	// canonical form throughout
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Delete(bKeys[perm[i]]), IsNil)
+		c.Assert(h.Delete(bKeys[perm[i]]), Equals, NotFound)
+		if i%512 == 0 {
+			c.Assert(h.Validate(), HasLen, 0)
+		}
+	}
+	c.Assert(h.GetLeafCount(), Equals, uint(0))
+	c.Assert(h.GetTableCount(), Equals, uint(1))
+}
+
+func (s *XLSuite) TestCompressedPathsForClusteredKeys(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_COMPRESSED_PATHS_FOR_CLUSTERED_KEYS")
+	}
+	// groups of keys whose hashcodes share their low 48 bits, each
+	// group in its own root slot
+	var keys []hc32Key
+	for g := uint64(0); g < 16; g++ {
+		for i := uint64(0); i < 4; i++ {
+			keys = append(keys, hc32Key{len(keys), i<<48 | g*0x1234567})
+		}
+	}
+	h, err := NewHAMT(4, 4)
+	c.Assert(err, IsNil)
+	p, err := NewHAMTWithCompressedPaths(4, 4)
+	c.Assert(err, IsNil)
+	for i := 0; i < len(keys); i++ {
+		c.Assert(h.Insert(keys[i], i), IsNil)
+		c.Assert(p.Insert(keys[i], i), IsNil)
+	}
+	c.Assert(p.Validate(), HasLen, 0)
+	for i := 0; i < len(keys); i++ {
+		value, err := p.Find(keys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+	// without compression each group makes a chain of 12 tables down to
+	// the one indexed by bits 48 to 51; with it, just that one
+	c.Assert(h.GetTableCount(), Equals, uint(1+16*12))
+	c.Assert(p.GetTableCount(), Equals, uint(1+16))
+	c.Assert(p.Stats().SkippedLevels, Equals, uint(16*11))
+	c.Assert(p.Stats().MaxDepth, Equals, uint(12))
+
+	var b bytes.Buffer
+	c.Assert(p.WriteText(&b, nil), IsNil)
+	c.Assert(strings.Contains(b.String(), "skipping 11 levels"), Equals, true)
+
+	// a key differing from a group in bit 20 misses at once, then on
+	// insertion splits the group's path at depth 5
+	extra := hc32Key{len(keys), 1<<20 | 3*0x1234567}
+	value, err := p.Find(extra)
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+	c.Assert(p.Delete(extra), Equals, NotFound)
+	c.Assert(p.Insert(extra, "x"), IsNil)
+	c.Assert(p.Validate(), HasLen, 0)
+	c.Assert(p.GetTableCount(), Equals, uint(1+16+1))
+	c.Assert(p.Stats().SkippedLevels, Equals, uint(16*11-1))
+	for i := 0; i < len(keys); i++ {
+		value, err := p.Find(keys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+	value, err = p.Find(extra)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "x")
+
+	// and deleting it joins the path back together
+	c.Assert(p.Delete(extra), IsNil)
+	c.Assert(p.Validate(), HasLen, 0)
+	c.Assert(p.GetTableCount(), Equals, uint(1+16))
+	c.Assert(p.Stats().SkippedLevels, Equals, uint(16*11))
+
+	// keys with the same hashcode still cannot both be inserted
+	c.Assert(p.Insert(hc32Key{99, keys[0].hc}, 99), Equals,
+		MaxTableDepthExceeded)
+	c.Assert(p.Validate(), HasLen, 0)
+	c.Assert(p.GetLeafCount(), Equals, uint(len(keys)))
+}
@@ -0,0 +1,216 @@
+package hamt_go
+
+// hamt_go/proof.go
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// A Table on the path through a Merkle HAMT from the Root towards a
+// key's leaf.
+type ProofTable struct {
+	Bitmap     uint64   // slots 0 to 63
+	WideBitmap []uint64 // slots 64 and up, 64 per word; nil unless w > 6
+	// The digests of the table's children in slot order.  If the path
+	// continues through this table, the digest of the child on the path
+	// is omitted, a nil taking its place.
+	Digests [][]byte
+}
+
+// A Proof shows that a key is or is not present in a Merkle HAMT with
+// a given root digest.  Because the path through the HAMT is fixed by
+// the key's hashcode, the path ends either at a leaf or at an empty
+// slot.  If the leaf holds the key, the key is present.  If the slot is
+// empty or the leaf holds some other key, the key is not present.
+type Proof struct {
+	W, T uint // table parameters of the HAMT
+
+	// If the widths of the HAMT's tables vary by depth, its schedule of
+	// widths, the first equal to W; otherwise nil.
+	Widths []uint
+
+	// Digests of siblings in the binary tree over the Root's slots,
+	// from the slot on the path up to the top of the tree.
+	Siblings [][]byte
+
+	// The Tables along the path, from depth 1 down.
+	Tables []ProofTable
+
+	// If the path ends at a leaf holding a different key, the serialized
+	// key and the digest of its value; otherwise nil.
+	OtherKey   []byte
+	OtherValue []byte
+}
+
+// Build a proof that key is or is not present in the HAMT.
+func (root *Root) prove(key KeyI) (proof *Proof, err error) {
+	if !root.merkle {
+		err = NotMerkleHAMT
+		return
+	}
+	kBytes, err := keyBytes(key)
+	if err != nil {
+		return
+	}
+	p := &Proof{W: root.w, T: root.t}
+	if len(root.schedule) > 1 {
+		p.Widths = append([]uint(nil), root.schedule...)
+	}
+
+	hc := key.Hashcode()
+	ndx := uint(hc & root.mask)
+	for i := root.slotCount + ndx; i > 1; i >>= 1 {
+		p.Siblings = append(p.Siblings, root.tree[i^1])
+	}
+	node := root.slot(uint64(ndx))
+	hc >>= root.t
+	for node != nil {
+		if node.IsLeaf() {
+			leaf := node.(*Leaf)
+			var leafKey []byte
+			leafKey, err = keyBytes(leaf.Key)
+			if err == nil && !bytes.Equal(leafKey, kBytes) {
+				p.OtherKey = leafKey
+				p.OtherValue, err = valueDigest(leaf.Value)
+			}
+			break
+		}
+		table := node.(*Table)
+		ndx := hc & uint64(table.mask)
+		bitmap := table.bitmap()
+		pt := ProofTable{Bitmap: bitmap[0]}
+		if len(bitmap) > 1 {
+			pt.WideBitmap = bitmap[1:]
+		}
+		for n := uint64(0); n <= uint64(table.mask); n++ {
+			if bitmapTest(bitmap, n) {
+				pt.Digests = append(pt.Digests, nodeDigest(table.childAt(n)))
+			}
+		}
+		node = table.childAt(ndx)
+		if node != nil {
+			pt.Digests[bitmapRank(bitmap, ndx)] = nil
+		}
+		p.Tables = append(p.Tables, pt)
+		hc >>= table.w
+	}
+	if err == nil {
+		proof = p
+	}
+	return
+}
+
+// Verify a proof against the digest of a Merkle HAMT.  If value is not
+// nil, the proof must show that the key is present with that value;
+// if value is nil, the proof must show that the key is not present.
+// Return nil if the proof is good and InvalidProof otherwise.  Nothing
+// but the digest is needed from the HAMT.
+func Verify(rootDigest []byte, key KeyI, value interface{}, proof *Proof) (
+	err error) {
+
+	if key == nil {
+		return NilKey
+	}
+	if proof == nil || proof.T >= 64 || uint(len(proof.Siblings)) != proof.T {
+		return InvalidProof
+	}
+	schedule := []uint{proof.W}
+	if proof.Widths != nil {
+		schedule = proof.Widths
+	}
+	schedule, err = normalizeWidths(schedule)
+	if err != nil || schedule[0] != proof.W ||
+		len(proof.Widths) != 0 && len(schedule) != len(proof.Widths) {
+		return InvalidProof
+	}
+	widths, _ := scheduleDepths(schedule, proof.T)
+	if len(proof.Tables) >= len(widths) {
+		return InvalidProof
+	}
+	kBytes, err := keyBytes(key)
+	if err != nil {
+		return
+	}
+
+	// the digest of whatever is found at the end of the path; nil if
+	// the path ends at an empty slot in a Table
+	var cur []byte
+	if value != nil {
+		if proof.OtherKey != nil {
+			return InvalidProof
+		}
+		var vDigest []byte
+		vDigest, err = valueDigest(value)
+		if err != nil {
+			return
+		}
+		cur = calcLeafDigest(kBytes, vDigest)
+	} else if proof.OtherKey != nil {
+		if bytes.Equal(proof.OtherKey, kBytes) ||
+			len(proof.OtherValue) != sha256.Size {
+			return InvalidProof
+		}
+		cur = calcLeafDigest(proof.OtherKey, proof.OtherValue)
+	} else if len(proof.Tables) == 0 {
+		cur = calcEmptyDigest()
+	}
+
+	// indices of the slots along the path through the Tables
+	hc := key.Hashcode()
+	rootNdx := hc & (uint64(1)<<proof.T - 1)
+	hc >>= proof.T
+	ndxs := make([]uint64, len(proof.Tables))
+	for i := 0; i < len(ndxs); i++ {
+		ndxs[i] = hc & (uint64(1)<<widths[i+1] - 1)
+		hc >>= widths[i+1]
+	}
+
+	// work up from the end of the path through the Tables
+	for i := len(proof.Tables) - 1; i >= 0; i-- {
+		pt := proof.Tables[i]
+		bitmap := append([]uint64{pt.Bitmap}, pt.WideBitmap...)
+		if len(bitmap) != bitmapWordCount(widths[i+1]) ||
+			len(pt.Digests) != bitmapCount(bitmap) {
+			return InvalidProof
+		}
+		children := make([][]byte, len(pt.Digests))
+		copy(children, pt.Digests)
+		if cur == nil {
+			// the path ends at an empty slot in this table
+			if bitmapTest(bitmap, ndxs[i]) {
+				return InvalidProof
+			}
+		} else {
+			if !bitmapTest(bitmap, ndxs[i]) {
+				return InvalidProof
+			}
+			slotNbr := bitmapRank(bitmap, ndxs[i])
+			if children[slotNbr] != nil {
+				return InvalidProof
+			}
+			children[slotNbr] = cur
+		}
+		for j := 0; j < len(children); j++ {
+			if len(children[j]) != sha256.Size {
+				return InvalidProof
+			}
+		}
+		cur = calcTableDigest(bitmap, children)
+	}
+
+	// then up the binary tree over the Root's slots
+	i := (uint64(1) << proof.T) + rootNdx
+	for j := 0; j < len(proof.Siblings); j++ {
+		if i&1 == 0 {
+			cur = calcInnerDigest(cur, proof.Siblings[j])
+		} else {
+			cur = calcInnerDigest(proof.Siblings[j], cur)
+		}
+		i >>= 1
+	}
+	if !bytes.Equal(calcRootDigest(schedule, proof.T, cur), rootDigest) {
+		err = InvalidProof
+	}
+	return
+}
@@ -0,0 +1,139 @@
+package hamt_go
+
+// hamt_go/proof_test.go
+
+import (
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestProofs(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_PROOFS")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestProofs(c, rng, 4, 4)
+	s.doTestProofs(c, rng, 5, 0)
+	s.doTestProofs(c, rng, 6, 8)
+	s.doTestProofs(c, rng, 8, 4)
+}
+
+func (s *XLSuite) doTestProofs(c *C, rng *xr.PRNG, w, t uint) {
+	const KEY_COUNT = 512
+	const ABSENT_COUNT = 256
+
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT+ABSENT_COUNT, 16)
+	h, err := NewMerkleHAMT(w, t)
+	c.Assert(err, IsNil)
+
+	// an empty HAMT: the path ends at an empty slot in the Root
+	digest := h.RootDigest()
+	proof, err := h.Prove(bKeys[0])
+	c.Assert(err, IsNil)
+	c.Assert(len(proof.Tables), Equals, 0)
+	c.Assert(Verify(digest, bKeys[0], nil, proof), IsNil)
+	c.Assert(Verify(digest, bKeys[0], rawKeys[0], proof), Equals, InvalidProof)
+
+	for i := 0; i < KEY_COUNT; i++ {
+		err = h.Insert(bKeys[i], rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	digest = h.RootDigest()
+
+	// inclusion proofs
+	for i := 0; i < KEY_COUNT; i++ {
+		proof, err := h.Prove(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(Verify(digest, bKeys[i], rawKeys[i], proof), IsNil)
+
+		// a proof of inclusion shows neither a different value nor absence
+		other := rawKeys[(i+1)%KEY_COUNT]
+		c.Assert(Verify(digest, bKeys[i], other, proof), Equals, InvalidProof)
+		c.Assert(Verify(digest, bKeys[i], nil, proof), Equals, InvalidProof)
+	}
+
+	// non-inclusion proofs; most of these paths end at a leaf holding
+	// some other key, the rest at an empty slot
+	for i := KEY_COUNT; i < KEY_COUNT+ABSENT_COUNT; i++ {
+		proof, err := h.Prove(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(Verify(digest, bKeys[i], nil, proof), IsNil)
+		c.Assert(Verify(digest, bKeys[i], rawKeys[i], proof),
+			Equals, InvalidProof)
+	}
+
+	// any tampering is detected
+	ndx := rng.Intn(KEY_COUNT)
+	proof, err = h.Prove(bKeys[ndx])
+	c.Assert(err, IsNil)
+	c.Assert(len(proof.Tables) > 0 || t > 0, Equals, true)
+	if t > 0 {
+		saved := proof.Siblings[0]
+		proof.Siblings[0] = proof.Siblings[1]
+		c.Assert(Verify(digest, bKeys[ndx], rawKeys[ndx], proof),
+			Equals, InvalidProof)
+		proof.Siblings[0] = saved
+	}
+	if len(proof.Tables) > 0 {
+		saved := proof.Tables[0].Bitmap
+		proof.Tables[0].Bitmap ^= 1 << 63
+		c.Assert(Verify(digest, bKeys[ndx], rawKeys[ndx], proof),
+			Equals, InvalidProof)
+		proof.Tables[0].Bitmap = saved
+	}
+	c.Assert(Verify(digest, bKeys[ndx], rawKeys[ndx], proof), IsNil)
+
+	// proofs are only good for the digest they were made against
+	err = h.Delete(bKeys[ndx])
+	c.Assert(err, IsNil)
+	c.Assert(Verify(h.RootDigest(), bKeys[ndx], rawKeys[ndx], proof),
+		Equals, InvalidProof)
+	proof, err = h.Prove(bKeys[ndx])
+	c.Assert(err, IsNil)
+	c.Assert(Verify(h.RootDigest(), bKeys[ndx], nil, proof), IsNil)
+}
+
+func (s *XLSuite) TestProofsOnLongPaths(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_PROOFS_ON_LONG_PATHS")
+	}
+	rng := xr.MakeSimpleRNG()
+	w := uint(5)
+	_, rawKeys := s.makePermutedKeys(rng, w)
+	KEY_COUNT := 64 / w
+
+	h, err := NewMerkleHAMT(w, w)
+	c.Assert(err, IsNil)
+	bKeys := make([]BytesKey, KEY_COUNT)
+	for i := uint(0); i < KEY_COUNT; i++ {
+		bKeys[i], err = NewBytesKey(rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	// insert every other key, so that the rest share long prefixes
+	// with keys which are present
+	for i := uint(0); i < KEY_COUNT; i += 2 {
+		err = h.Insert(bKeys[i], rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	digest := h.RootDigest()
+	for i := uint(0); i < KEY_COUNT; i++ {
+		proof, err := h.Prove(bKeys[i])
+		c.Assert(err, IsNil)
+		if i%2 == 0 {
+			c.Assert(Verify(digest, bKeys[i], rawKeys[i], proof), IsNil)
+		} else {
+			c.Assert(Verify(digest, bKeys[i], nil, proof), IsNil)
+			c.Assert(Verify(digest, bKeys[i], rawKeys[i], proof),
+				Equals, InvalidProof)
+		}
+	}
+
+	// a HAMT which does not maintain digests cannot prove anything
+	h2, err := NewHAMT(w, w)
+	c.Assert(err, IsNil)
+	_, err = h2.Prove(bKeys[0])
+	c.Assert(err, Equals, NotMerkleHAMT)
+}
@@ -3,41 +3,93 @@ package hamt_go
 // hamt_go/root.go
 
 import (
-	"bytes"
 	"fmt"
+	"sync"
 )
 
 var _ = fmt.Print
 
 type Root struct {
-	w             uint // non-root tables have 2^w slots
-	t             uint // root table has 2^t slots
-	maxTableDepth uint // max depth of descendent Tables
-	slotCount     uint // number of slots in the root table
+	w             uint   // tables at depth 1 have 2^w slots
+	t             uint   // root table has 2^t slots
+	maxTableDepth uint   // max depth of descendent Tables
+	schedule      []uint // widths by depth, in canonical form; see widths.go
+	widths        []uint // widths[d] is w for Tables at depth d; widths[0] = t
+	shifts        []uint // shifts[d] is how many hashcode bits lie above depth d
+	slotCount     uint   // number of slots in the root table
 	mask          uint64
-	slots         []HTNodeI // each nil or a pointer to either a leaf or a table
+	slots         []HTNodeI  // each nil or a pointer to either a leaf or a table
+	groups        *rootGroup // in place of slots if sparse; see sparseRoot.go
+	groupShift    uint       // bits of a slot number below the top group's
+	merkle        bool       // if true, maintain digests; see merkle.go
+	uint64Keys    bool       // if true, Tables hold ukeys; see uint64Map.go
+	pageSize      uint       // if more than 1, max entries in a page; see pages.go
+	compressPaths bool       // if true, Tables may skip levels; see paths.go
+	buckets       bool       // if true, keys may collide; see pages.go
+	tree          [][]byte   // binary Merkle tree over slots; nil unless merkle
+
+	// Set by the Options given to New; see options.go.
+	hasher     func(KeyI) uint64 // if not nil, used in place of Hashcode
+	lock       *sync.RWMutex     // nil unless Synchronized
+	hook       MetricsHook       // nil unless WithMetrics
+	maxEntries uint              // if more than 0, the most entries allowed
+	entries    uint              // number of entries; kept only if maxEntries > 0
+	copyKeys   bool              // if true, Insert copies keys; KeysCopied
+}
+
+// Return the hashcode by which key is placed in the trie: the key's
+// own, unless the HAMT was created with a hasher.
+func (root *Root) hash(key KeyI) uint64 {
+	if root.hasher != nil {
+		return root.hasher(key)
+	}
+	return key.Hashcode()
 }
 
 func NewRoot(w, t uint) (root *Root, err error) {
-	if w > MAX_W {
-		err = MaxTableSizeExceeded
-	} else if t > 64 { // very generous!
+	schedule, err := normalizeWidths([]uint{w})
+	if err == nil {
+		root, err = newRootWithWidths(schedule, t, false)
+	}
+	return
+}
+
+// Create a Root whose Tables follow a schedule of widths by depth,
+// already in canonical form.  If sparse, its slots are held in groups;
+// see sparseRoot.go.
+func newRootWithWidths(schedule []uint, t uint, sparse bool) (
+	root *Root, err error) {
+
+	if t > 64 { // very generous!
 		err = MaxRootTableSizeExceeded
+	} else if sparse && t > maxSparseT {
+		err = MaxSparseRootExceeded
 	} else {
 		flag := uint64(1)
 		flag <<= t
 		count := uint(1 << t) // number of slots
+		widths, shifts := scheduleDepths(schedule, t)
 		root = &Root{
-			w: w,
+			w: schedule[0],
 			t: t,
-			// The maximum possible depth for any table below the root, (64 - t)/w.
-			// There are 64 bits available for keys, the root table uses t, each
-			// successive Table uses w more bits.  The root table (of type Root)
-			// is at depth 0;  all Tables at at depth >= 1.
-			maxTableDepth: (64 - t) / w,
+			// The maximum possible depth for any table below the root.
+			// There are 64 bits available for keys, the root table uses t,
+			// and each successive Table uses the width for its depth, the
+			// last perhaps fewer.  The root table (of type Root) is at
+			// depth 0;  all Tables at at depth >= 1.  For a single width w
+			// this is (64 - t)/w, rounded up.
+			maxTableDepth: uint(len(widths) - 1),
+			schedule:      schedule,
+			widths:        widths,
+			shifts:        shifts,
 			slotCount:     count,
 			mask:          flag - 1,
-			slots:         make([]HTNodeI, count),
+		}
+		if sparse {
+			root.groups = new(rootGroup)
+			root.groupShift = rootGroupShift(t)
+		} else {
+			root.slots = make([]HTNodeI, count)
 		}
 	}
 	return
@@ -45,20 +97,13 @@ func NewRoot(w, t uint) (root *Root, err error) {
 
 // Return a count of leaf nodes in the root
 func (root *Root) getLeafCount() (count uint) {
-	if root.slots != nil {
-		for i := uint(0); i < root.slotCount; i++ {
-			if root.slots[i] != nil {
-				node := root.slots[i]
-				if node != nil {
-					if node.IsLeaf() {
-						count++
-					} else {
-						// recurse
-						table := node.(*Table)
-						count += table.getLeafCount()
-					}
-				}
-			}
+	for i, node := root.nextSlot(0); node != nil; i, node = root.nextSlot(i + 1) {
+		if node.IsLeaf() {
+			count++
+		} else {
+			// recurse
+			table := node.(*Table)
+			count += table.getLeafCount()
 		}
 	}
 	return
@@ -67,15 +112,10 @@ func (root *Root) getLeafCount() (count uint) {
 // Return a count of tables (including the root) in the HAMT
 func (root *Root) getTableCount() (count uint) {
 	count = 1 // we include the root in the count
-	if root.slots != nil {
-		for i := uint(0); i < root.slotCount; i++ {
-			if root.slots[i] != nil {
-				node := root.slots[i]
-				if node != nil && !node.IsLeaf() {
-					tDeeper := node.(*Table)
-					count += tDeeper.getTableCount()
-				}
-			}
+	for i, node := root.nextSlot(0); node != nil; i, node = root.nextSlot(i + 1) {
+		if !node.IsLeaf() {
+			tDeeper := node.(*Table)
+			count += tDeeper.getTableCount()
 		}
 	}
 	return
@@ -83,21 +123,19 @@ func (root *Root) getTableCount() (count uint) {
 
 func (root *Root) deleteLeaf(key KeyI) (err error) {
 
-	hc := key.Hashcode()
+	hc := root.hash(key)
 	ndx := hc & root.mask
-	if root.slots[ndx] == nil {
+	node := root.slot(ndx)
+	if node == nil {
 		err = NotFound
 	}
 	if err == nil {
 		// the entry is present
-		node := root.slots[ndx]
 		if node.IsLeaf() {
 			// KEYS MUST BE OF THE SAME TYPE
 			myLeaf := node.(*Leaf)
-			myKey := myLeaf.Key.(BytesKey)
-			searchKey := key.(BytesKey)
-			if bytes.Equal(searchKey.Slice, myKey.Slice) {
-				root.slots[ndx] = nil
+			if sameKey(myLeaf.Key, key) {
+				root.setSlot(ndx, nil)
 			} else {
 				err = NotFound
 			}
@@ -109,93 +147,119 @@ func (root *Root) deleteLeaf(key KeyI) (err error) {
 				tDeeper := node.(*Table)
 				hc >>= root.t
 				err = tDeeper.deleteLeaf(hc, 1, key)
+				if err == nil {
+					// keep the trie in canonical form
+					if len(tDeeper.nodes) == 0 && len(tDeeper.values) <= 1 {
+						if len(tDeeper.values) == 0 {
+							root.setSlot(ndx, nil)
+						} else {
+							root.setSlot(ndx, tDeeper.leafAt(0))
+						}
+					} else if page := tDeeper.pageIfSmall(1); page != nil {
+						root.setSlot(ndx, page)
+					} else if child := tDeeper.joinPath(); child != nil {
+						root.setSlot(ndx, child)
+					}
+				}
 			}
 		}
+		if err == nil && root.merkle {
+			root.updateSlotDigest(uint(ndx))
+		}
 	}
 	return
 }
 
-// Given a properly shifted hashCode and the full key for any entry,
-// return the value associated with the key, nil if there is no such
-// value, or any error encountered.
+// Given the full key for any entry, return the value associated with
+// the key, nil if there is no such value, or any error encountered.
 func (root *Root) findLeaf(key KeyI) (value interface{}, err error) {
 
-	hc := key.Hashcode()
-	ndx := hc & root.mask
-	p := &root.slots
-	if (*p)[ndx] != nil {
-		// the entry is present
-		node := (*p)[ndx]
-		if node.IsLeaf() {
-			myLeaf := node.(*Leaf)
-			myKey := myLeaf.Key.(BytesKey)
-			searchKey := key.(BytesKey)
-			if bytes.Equal(searchKey.Slice, myKey.Slice) {
-				value = myLeaf.Value
-			} else {
-				value = nil
-			}
-		} else {
-			if 1 <= root.maxTableDepth {
-				// entry is a table, so recurse
-				tDeeper := node.(*Table)
-				hc >>= root.t
-				value, err = tDeeper.findLeaf(hc, 1, key)
-			}
+	hc := root.hash(key)
+	switch node := root.slot(hc & root.mask).(type) {
+	case *Table:
+		if 1 <= root.maxTableDepth {
+			value, err = node.findLeaf(hc>>root.t, 1, key)
+		}
+	case *Leaf:
+		if sameKey(node.Key, key) {
+			value = node.Value
+		}
+	}
+	return
+}
+
+// As findLeaf, but given the key's hashcode.  The key is only compared,
+// never stored or called through, so a key converted to a KeyI by the
+// caller need not escape to the heap.
+func (root *Root) findHashed(hc uint64, key KeyI) (value interface{}) {
+	switch node := root.slot(hc & root.mask).(type) {
+	case *Table:
+		if 1 <= root.maxTableDepth {
+			value, _ = node.findLeaf(hc>>root.t, 1, key)
+		}
+	case *Leaf:
+		if sameKey(node.Key, key) {
+			value = node.Value
 		}
 	}
 	return
 }
 
-func (root *Root) insertLeaf(leaf *Leaf) (err error) {
+// Insert an entry, given its key and value and, in a Merkle HAMT, its
+// digest.  A Leaf is allocated only if the entry goes in a Root slot;
+// deeper entries are held inline in Tables.
+func (root *Root) insertLeaf(key KeyI, value interface{}, digest []byte) (
+	err error) {
 
-	newHC := leaf.Key.Hashcode()
+	newHC := root.hash(key)
 	slotNbr := uint(newHC & root.mask)
 
-	p := &root.slots
-	if (*p)[slotNbr] == nil {
-		(*p)[slotNbr] = leaf
-	} else {
-		// there is already something in this slot
-		node := (*p)[slotNbr]
-		if node.IsLeaf() {
-			// if it's a leaf, we replace the value iff the keys match
-			oldLeaf := node.(*Leaf)
-			curKey := oldLeaf.Key.(BytesKey)
-			newKey := leaf.Key.(BytesKey)
-			if bytes.Equal(curKey.Slice, newKey.Slice) {
-				// the keys match, so we replace the value
-				oldLeaf.Value = leaf.Value
+	switch node := root.slot(uint64(slotNbr)).(type) {
+	case nil:
+		root.setSlot(uint64(slotNbr), &Leaf{Key: key, Value: value, digest: digest})
+	case *Table:
+		if 1 > root.maxTableDepth {
+			err = MaxTableDepthExceeded
+		} else {
+			// it's a table, which takes care of any further levels
+			err = node.insertLeaf(newHC>>root.t, 1, key, value, digest)
+		}
+	case *Leaf:
+		// if it's a leaf, we replace the value iff the keys match
+		if sameKey(node.Key, key) {
+			// the keys match, so we replace the value
+			node.Value = value
+			node.digest = digest
+		} else if 1 > root.maxTableDepth {
+			err = MaxTableDepthExceeded
+		} else {
+			// keys differ, so we need to replace the leaf with a table
+			// containing the existing leaf and then the new entry
+			var tableDeeper *Table
+			if root.pageSize > 1 {
+				// or with a page holding both
+				tableDeeper = newPage(1, root)
+				tableDeeper.keys = append(tableDeeper.keys, node.Key, key)
+				tableDeeper.values = append(tableDeeper.values, node.Value, value)
 			} else {
-				// keys differ, so we need to replace the leaf with a table
-				// Create a new Table containing the existing leaf
-				var tableDeeper *Table
-				tableDeeper, err = NewTableWithLeaf(1, root, oldLeaf)
+				if root.compressPaths {
+					tableDeeper, err = newPathTable(1, root, newHC>>root.t,
+						node.Key, node.Value)
+				} else {
+					tableDeeper, err = NewTableWithLeaf(1, root, node)
+				}
 				if err == nil {
-					if 1 > root.maxTableDepth {
-						err = MaxTableDepthExceeded
-					} else {
-						newHC >>= root.t // this is hc for the NEW entry
-						// then put the new entry in the new table
-						err = tableDeeper.insertLeaf(newHC, 1, leaf)
-						if err == nil {
-							// the new table replaces the existing leaf
-							(*p)[slotNbr] = tableDeeper
-						}
-					}
+					err = tableDeeper.insertLeaf(newHC>>root.t, 1, key, value, digest)
 				}
 			}
-		} else {
-			if 1 > root.maxTableDepth {
-				err = MaxTableDepthExceeded
-			} else {
-				// otherwise it's a table, so recurse
-				tDeeper := node.(*Table)
-				newHC >>= root.t
-				err = tDeeper.insertLeaf(newHC, 1, leaf)
+			if err == nil {
+				root.setSlot(uint64(slotNbr), tableDeeper)
 			}
 		}
 	}
+	if err == nil && root.merkle {
+		root.updateSlotDigest(slotNbr)
+	}
 	return
 }
 
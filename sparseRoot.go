@@ -0,0 +1,173 @@
+package hamt_go
+
+// hamt_go/sparseRoot.go
+
+import (
+	"math/bits"
+)
+
+// A dense Root holds a slice of 2^t slots, allocated in full when the
+// Root is created; with t of 24, that is a quarter of a gigabyte before
+// anything is inserted.  A sparse Root instead compresses its slots as
+// a Table does, in groups of 64: each group has a bitmap marking the
+// slots in use and a slice holding their nodes in slot order.  The
+// groups are themselves reached through a directory of such groups,
+// each indexing 64 groups of the level below by the next six bits of
+// the slot number, with only the groups in use allocated.  An empty
+// sparse Root is then a single empty group, whatever t, and the memory
+// used grows with the number of slots occupied.  Reaching a slot takes
+// a popcount at each level of the directory, ceil(t/6) in all, where a
+// dense Root indexes its slots directly.
+//
+// Sparse Roots are not used by Merkle HAMTs.  t may be at most 63, so
+// that the slot count, 2^t, is a uint and the slot after the last is
+// not slot 0.
+
+const (
+	rootGroupBits = 6
+	maxSparseT    = 63
+)
+
+// A group in the directory of a sparse Root.  At the lowest level a
+// group holds nodes; above that, it holds groups.
+type rootGroup struct {
+	bitmap uint64      // bit n set if slot or group n is in use
+	nodes  []HTNodeI   // one per bit in bitmap, in slot order
+	groups []rootGroup // in place of nodes above the lowest level
+}
+
+// Return the position of the bits of a slot number indexing the top
+// group of the directory of a sparse Root of 2^t slots.
+func rootGroupShift(t uint) (shift uint) {
+	for shift+rootGroupBits < t {
+		shift += rootGroupBits
+	}
+	return
+}
+
+// Return the node in slot ndx of the Root, nil if the slot is empty.
+func (root *Root) slot(ndx uint64) HTNodeI {
+	if root.groups == nil {
+		return root.slots[ndx]
+	}
+	return root.sparseSlot(ndx)
+}
+
+// Return the node in slot ndx of a sparse Root.
+func (root *Root) sparseSlot(ndx uint64) HTNodeI {
+	g := root.groups
+	for shift := root.groupShift; shift > 0; shift -= rootGroupBits {
+		flag := uint64(1) << (ndx >> shift & (1<<rootGroupBits - 1))
+		if g.bitmap&flag == 0 {
+			return nil
+		}
+		g = &g.groups[bits.OnesCount64(g.bitmap&(flag-1))]
+	}
+	flag := uint64(1) << (ndx & (1<<rootGroupBits - 1))
+	if g.bitmap&flag == 0 {
+		return nil
+	}
+	return g.nodes[bits.OnesCount64(g.bitmap&(flag-1))]
+}
+
+// Put node, which may be nil, in slot ndx of the Root.
+func (root *Root) setSlot(ndx uint64, node HTNodeI) {
+	if root.groups == nil {
+		root.slots[ndx] = node
+		return
+	}
+	root.groups.set(ndx, root.groupShift, node)
+}
+
+// Put node, which may be nil, in slot ndx below the group, whose slots
+// or groups are indexed by the bits of ndx from shift up.  Groups left
+// empty are removed from their parents, except the top group.
+func (g *rootGroup) set(ndx uint64, shift uint, node HTNodeI) {
+	flag := uint64(1) << (ndx >> shift & (1<<rootGroupBits - 1))
+	offset := uint(bits.OnesCount64(g.bitmap & (flag - 1)))
+	if shift == 0 {
+		if g.bitmap&flag != 0 {
+			if node != nil {
+				g.nodes[offset] = node
+			} else {
+				g.nodes = removeAt(g.nodes, offset)
+				g.bitmap &^= flag
+			}
+		} else if node != nil {
+			g.nodes = insertAt(g.nodes, offset, node)
+			g.bitmap |= flag
+		}
+	} else if g.bitmap&flag != 0 {
+		sub := &g.groups[offset]
+		sub.set(ndx, shift-rootGroupBits, node)
+		if sub.bitmap == 0 {
+			g.groups = removeAt(g.groups, offset)
+			g.bitmap &^= flag
+		}
+	} else if node != nil {
+		var sub rootGroup
+		sub.set(ndx, shift-rootGroupBits, node)
+		g.groups = insertAt(g.groups, offset, sub)
+		g.bitmap |= flag
+	}
+	if g.bitmap == 0 {
+		g.nodes, g.groups = nil, nil // let the garbage collector have them
+	}
+}
+
+// Return the first occupied slot at or after slot i and its node, or
+// slotCount and nil if there is none.  Successive calls visit the
+// occupied slots in order:
+//
+//	for i, node := root.nextSlot(0); node != nil; i, node = root.nextSlot(i + 1) {
+func (root *Root) nextSlot(i uint) (uint, HTNodeI) {
+	if root.groups == nil {
+		for ; i < root.slotCount; i++ {
+			if root.slots[i] != nil {
+				return i, root.slots[i]
+			}
+		}
+		return root.slotCount, nil
+	}
+	if i < root.slotCount {
+		if ndx, node := root.groups.next(uint64(i), root.groupShift); node != nil {
+			return uint(ndx), node
+		}
+	}
+	return root.slotCount, nil
+}
+
+// Return the first occupied slot at or after slot i below the group,
+// indexed by the bits of i from shift up, and its node, or nil if
+// there is none.
+func (g *rootGroup) next(i uint64, shift uint) (uint64, HTNodeI) {
+	chunk := i >> shift & (1<<rootGroupBits - 1)
+	bitmap := g.bitmap &^ (uint64(1)<<chunk - 1)
+	for bitmap != 0 {
+		n := uint64(bits.TrailingZeros64(bitmap))
+		offset := bits.OnesCount64(g.bitmap & (uint64(1)<<n - 1))
+		// the slot number of the first slot in slot or group n, or i
+		// itself if that is in it
+		start := i
+		if n != chunk {
+			start = i&^(uint64(1)<<(shift+rootGroupBits)-1) | n<<shift
+		}
+		if shift == 0 {
+			return start, g.nodes[offset]
+		}
+		if ndx, node := g.groups[offset].next(start, shift-rootGroupBits); node != nil {
+			return ndx, node
+		}
+		bitmap &^= uint64(1) << n
+	}
+	return 0, nil
+}
+
+// Return the number of groups in the directory below and including g.
+func (g *rootGroup) count() (n uint) {
+	n = 1
+	for i := 0; i < len(g.groups); i++ {
+		n += g.groups[i].count()
+	}
+	return
+}
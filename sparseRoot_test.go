@@ -0,0 +1,164 @@
+package hamt_go
+
+// hamt_go/sparseRoot_test.go
+
+import (
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestSparseRoot(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SPARSE_ROOT")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestSparseRoot(c, rng, 5, 4)
+	s.doTestSparseRoot(c, rng, 6, 6)
+	s.doTestSparseRoot(c, rng, 6, 16)
+	s.doTestSparseRoot(c, rng, 8, 20)
+}
+
+func (s *XLSuite) doTestSparseRoot(c *C, rng *xr.PRNG, w, t uint) {
+	const KEY_COUNT = 4096
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	h, err := NewSparseHAMT(w, t)
+	c.Assert(err, IsNil)
+	c.Assert(h.IsSparse(), Equals, true)
+	d, err := NewHAMT(w, t)
+	c.Assert(err, IsNil)
+	c.Assert(d.IsSparse(), Equals, false)
+	c.Assert(h.Validate(), HasLen, 0)
+
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+		c.Assert(d.Insert(bKeys[i], &rawKeys[i]), IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT))
+	c.Assert(h.GetTableCount(), Equals, d.GetTableCount())
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := h.Find(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, &rawKeys[i])
+	}
+
+	// the occupied slots are visited in order, and are those of the
+	// dense root
+	var prev uint
+	count := 0
+	for i, node := h.root.nextSlot(0); node != nil; i, node = h.root.nextSlot(i + 1) {
+		if count > 0 {
+			c.Assert(i > prev, Equals, true)
+		}
+		c.Assert(d.root.slots[i], NotNil)
+		c.Assert(node.IsLeaf(), Equals, d.root.slots[i].IsLeaf())
+		prev = i
+		count++
+	}
+	st, dst := h.Stats(), d.Stats()
+	c.Assert(uint(count), Equals, st.RootLeaves+st.RootTables)
+	c.Assert(st.RootLeaves, Equals, dst.RootLeaves)
+	c.Assert(st.RootTables, Equals, dst.RootTables)
+	if t >= 16 {
+		// with few slots in use the sparse root is much the smaller
+		c.Assert(st.Bytes < dst.Bytes/2, Equals, true)
+	}
+
+	// the frozen copy finds the same values
+	if w <= maxWordW {
+		f, err := h.Freeze()
+		c.Assert(err, IsNil)
+		for i := 0; i < KEY_COUNT; i++ {
+			value, err := f.Find(bKeys[i])
+			c.Assert(err, IsNil)
+			c.Assert(value, Equals, &rawKeys[i])
+		}
+	}
+
+	// deleting all the keys empties the root
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Delete(bKeys[perm[i]]), IsNil)
+		if i == KEY_COUNT/2 {
+			c.Assert(h.Validate(), HasLen, 0)
+		}
+	}
+	c.Assert(h.GetLeafCount(), Equals, uint(0))
+	c.Assert(h.Validate(), HasLen, 0)
+	_, node := h.root.nextSlot(0)
+	c.Assert(node, IsNil)
+	c.Assert(h.root.groups.bitmap, Equals, uint64(0))
+	c.Assert(h.root.groups.nodes, IsNil)
+	c.Assert(h.root.groups.groups, IsNil)
+}
+
+func (s *XLSuite) TestSparseRootWithLargeT(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SPARSE_ROOT_WITH_LARGE_T")
+	}
+	const KEY_COUNT = 4096
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+	rng := xr.MakeSimpleRNG()
+	// 2^64 slots cannot be counted in a uint
+	_, err := NewSparseHAMT(6, 64)
+	c.Assert(err, Equals, MaxSparseRootExceeded)
+	_, err = New(WithSparseRoot(), WithT(64))
+	c.Assert(err, Equals, MaxSparseRootExceeded)
+
+	for _, t := range []uint{40, 63} {
+		// an empty sparse root is a single group, whatever t
+		h, err := NewSparseHAMT(6, t)
+		c.Assert(err, IsNil)
+		c.Assert(h.Stats().Bytes, Equals, sizeofRoot+sizeofRootGroup)
+		c.Assert(h.Validate(), HasLen, 0)
+
+		for i := 0; i < KEY_COUNT; i++ {
+			c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+		}
+		c.Assert(h.Validate(), HasLen, 0)
+		c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT))
+		// every key has a root slot to itself, and the directory costs
+		// a few groups per key
+		st := h.Stats()
+		c.Assert(st.RootLeaves, Equals, uint(KEY_COUNT))
+		c.Assert(st.BytesPerEntry < 1000, Equals, true)
+		c.Assert(st.RootSlotCount, Equals, uint(1)<<t)
+		for i := 0; i < KEY_COUNT; i++ {
+			value, err := h.Find(bKeys[i])
+			c.Assert(err, IsNil)
+			c.Assert(value, Equals, &rawKeys[i])
+		}
+		_, err = h.Freeze()
+		c.Assert(err, Equals, MaxFrozenRootExceeded)
+
+		perm := rng.Perm(KEY_COUNT)
+		for i := 0; i < KEY_COUNT; i++ {
+			c.Assert(h.Delete(bKeys[perm[i]]), IsNil)
+			if i%1024 == 0 {
+				c.Assert(h.Validate(), HasLen, 0)
+			}
+		}
+		c.Assert(h.GetLeafCount(), Equals, uint(0))
+		c.Assert(h.Stats().Bytes, Equals, sizeofRoot+sizeofRootGroup)
+	}
+
+	// visiting the slots ends after the last, even when it is occupied
+	h, err := NewSparseHAMT(6, maxSparseT)
+	c.Assert(err, IsNil)
+	last := uint64(1)<<maxSparseT - 1
+	c.Assert(h.Insert(hc32Key{n: 1, hc: last}, "last"), IsNil)
+	c.Assert(h.Insert(hc32Key{n: 2, hc: 0}, "first"), IsNil)
+	var visited []uint
+	for i, node := h.root.nextSlot(0); node != nil; i, node = h.root.nextSlot(i + 1) {
+		visited = append(visited, i)
+		c.Assert(len(visited) <= 2, Equals, true)
+	}
+	c.Assert(visited, DeepEquals, []uint{0, uint(last)})
+	c.Assert(strings.Contains(h.Stats().String(), "0.0% used"), Equals, true)
+	c.Assert((&Stats{}).String(), Matches, "(?s).*root: 0 slots.* 0.0% used.*")
+}
@@ -0,0 +1,194 @@
+package hamt_go
+
+// hamt_go/stats.go
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// Structural statistics for a HAMT, for use in choosing w and t from
+// data.  Depths are counted as in Root and Table: the Root is at depth
+// 0, and Tables are at depth 1 and below.  A leaf's lookup depth is the
+// depth of the table holding it, so a leaf found in the Root has a
+// lookup depth of zero.
+type Stats struct {
+	W, T       uint
+	Widths     []uint // widths by depth from 1; the last applies below
+	LeafCount  uint
+	TableCount uint // including the Root
+	PageCount  uint // leaf pages, not counted as tables; see pages.go
+
+	// SkippedLevels is the number of levels skipped by compressed paths,
+	// summed over all Tables; see paths.go.
+	SkippedLevels uint
+
+	LeavesAtDepth []uint // number of leaves held in tables at each depth
+	TablesAtDepth []uint // number of tables at each depth; 1 at depth 0
+
+	// TableFill[n] is the number of Tables, excluding the Root, using n
+	// of their 2^w slots; that is, with a bitmap popcount of n.
+	TableFill []uint
+
+	RootSlotCount uint // 2^t
+	RootLeaves    uint // Root slots holding a leaf
+	RootTables    uint // Root slots holding a Table
+
+	MaxDepth uint    // greatest lookup depth of any leaf
+	AvgDepth float64 // mean lookup depth over all leaves
+
+	// Estimated memory used by the trie itself, excluding keys and
+	// values, in total and per leaf.
+	Bytes         uint64
+	BytesPerEntry float64
+}
+
+// Approximate sizes in bytes of the structures making up the trie.
+var (
+	sizeofRoot      = uint64(unsafe.Sizeof(Root{}))
+	sizeofTable     = uint64(unsafe.Sizeof(Table{}))
+	sizeofRoot32    = uint64(unsafe.Sizeof(root32{}))
+	sizeofTable32   = uint64(unsafe.Sizeof(table32{}))
+	sizeofLeaf      = uint64(unsafe.Sizeof(Leaf{}))
+	sizeofWideMaps  = uint64(unsafe.Sizeof(wideMaps{}))
+	sizeofRootGroup = uint64(unsafe.Sizeof(rootGroup{}))
+	sizeofNodeI     = uint64(unsafe.Sizeof(HTNodeI(nil)))
+	sizeofPtr       = uint64(unsafe.Sizeof(uintptr(0)))
+	sizeofSlice     = uint64(unsafe.Sizeof([]byte(nil)))
+	sizeofDigest    = uint64(32)
+	sizeofTreeEntry = sizeofSlice + sizeofDigest
+)
+
+// Collect statistics by walking the entire trie.
+func (root *Root) getStats() (st *Stats) {
+	st = &Stats{
+		W:             root.w,
+		Widths:        append([]uint(nil), root.schedule...),
+		T:             root.t,
+		TableCount:    1,
+		LeavesAtDepth: []uint{0},
+		TablesAtDepth: []uint{1},
+		TableFill:     make([]uint, (1<<maxWidth(root.schedule))+1),
+		RootSlotCount: root.slotCount,
+	}
+	if root.groups != nil {
+		st.Bytes = sizeofRoot + uint64(root.groups.count())*sizeofRootGroup
+	} else {
+		st.Bytes = sizeofRoot + uint64(root.slotCount)*sizeofNodeI
+	}
+	if root.merkle {
+		st.Bytes += uint64(len(root.tree)) * sizeofTreeEntry
+	}
+	var depthSum uint64
+	for i, node := root.nextSlot(0); node != nil; i, node = root.nextSlot(i + 1) {
+		if node.IsLeaf() {
+			st.RootLeaves++
+		} else {
+			st.RootTables++
+		}
+		if root.groups != nil {
+			st.Bytes += sizeofNodeI
+		}
+		depthSum += st.addNode(root, node, 0)
+	}
+	if st.LeafCount > 0 {
+		st.AvgDepth = float64(depthSum) / float64(st.LeafCount)
+		st.BytesPerEntry = float64(st.Bytes) / float64(st.LeafCount)
+	}
+	return
+}
+
+// Add a node held in a table at the depth specified, and anything
+// below it, to the statistics.  Return the sum of the lookup depths of
+// the leaves added.
+func (st *Stats) addNode(root *Root, node HTNodeI, depth uint) (
+	depthSum uint64) {
+
+	if node.IsLeaf() {
+		st.Bytes += sizeofLeaf
+		depthSum = st.addEntry(root, depth)
+	} else {
+		table := node.(*Table)
+		depth += 1 + uint(table.skip)
+		st.SkippedLevels += uint(table.skip)
+		for uint(len(st.TablesAtDepth)) <= depth {
+			st.TablesAtDepth = append(st.TablesAtDepth, 0)
+			st.LeavesAtDepth = append(st.LeavesAtDepth, 0)
+		}
+		if table.isPage() {
+			st.PageCount++
+		} else {
+			st.TableCount++
+			st.TablesAtDepth[depth]++
+			st.TableFill[table.usedSlots()]++
+		}
+		st.Bytes += sizeofTable +
+			uint64(cap(table.keys)+cap(table.values))*sizeofNodeI +
+			uint64(cap(table.ukeys))*8 + uint64(cap(table.nodes))*sizeofPtr
+		if table.wide != nil {
+			st.Bytes += sizeofWideMaps
+		}
+		if root.merkle {
+			st.Bytes += sizeofDigest + uint64(cap(table.digests))*sizeofSlice
+		}
+		for i := 0; i < len(table.values); i++ {
+			depthSum += st.addEntry(root, depth)
+		}
+		for i := 0; i < len(table.nodes); i++ {
+			depthSum += st.addNode(root, table.nodes[i], depth)
+		}
+	}
+	return
+}
+
+// Add an entry held in a table at the depth specified to the
+// statistics, returning its lookup depth.  The caller accounts for any
+// Leaf or slice holding the entry.
+func (st *Stats) addEntry(root *Root, depth uint) uint64 {
+	st.LeafCount++
+	st.LeavesAtDepth[depth]++
+	if depth > st.MaxDepth {
+		st.MaxDepth = depth
+	}
+	if root.merkle {
+		st.Bytes += sizeofDigest
+	}
+	return uint64(depth)
+}
+
+// Return a multi-line report on the statistics.
+func (st *Stats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "w %s, t %d: %d leaves, %d tables",
+		formatWidths(st.Widths), st.T, st.LeafCount, st.TableCount)
+	if st.PageCount > 0 {
+		fmt.Fprintf(&b, ", %d pages", st.PageCount)
+	}
+	if st.SkippedLevels > 0 {
+		fmt.Fprintf(&b, ", %d levels skipped", st.SkippedLevels)
+	}
+	fmt.Fprintf(&b, "\n")
+	var used float64
+	if st.RootSlotCount > 0 {
+		used = 100.0 * float64(st.RootLeaves+st.RootTables) /
+			float64(st.RootSlotCount)
+	}
+	fmt.Fprintf(&b, "root: %d slots, %d leaves, %d tables, %.1f%% used\n",
+		st.RootSlotCount, st.RootLeaves, st.RootTables, used)
+	fmt.Fprintf(&b, "depth  tables   leaves\n")
+	for d := 0; d < len(st.TablesAtDepth); d++ {
+		fmt.Fprintf(&b, "%5d %7d %8d\n",
+			d, st.TablesAtDepth[d], st.LeavesAtDepth[d])
+	}
+	fmt.Fprintf(&b, "table fill (slots used: tables)\n")
+	for n := 0; n < len(st.TableFill); n++ {
+		if st.TableFill[n] > 0 {
+			fmt.Fprintf(&b, "%5d: %7d\n", n, st.TableFill[n])
+		}
+	}
+	fmt.Fprintf(&b, "lookup depth: max %d, avg %.3f\n", st.MaxDepth, st.AvgDepth)
+	fmt.Fprintf(&b, "%.2f megabytes, %.1f bytes/entry\n",
+		float64(st.Bytes)/(1000*1000), st.BytesPerEntry)
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package hamt_go
+
+// hamt_go/stats_test.go
+
+import (
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestStats(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_STATS")
+	}
+	s.doTestStats(c, 4, 4, false)
+	s.doTestStats(c, 5, 8, true)
+	s.doTestStats(c, 6, 10, false)
+}
+
+func (s *XLSuite) doTestStats(c *C, w, t uint, merkle bool) {
+	const KEY_COUNT = 4096
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	var h HAMT
+	var err error
+	if merkle {
+		h, err = NewMerkleHAMT(w, t)
+	} else {
+		h, err = NewHAMT(w, t)
+	}
+	c.Assert(err, IsNil)
+
+	st := h.Stats()
+	c.Assert(st.LeafCount, Equals, uint(0))
+	c.Assert(st.TableCount, Equals, uint(1))
+	c.Assert(st.RootSlotCount, Equals, uint(1)<<t)
+	c.Assert(st.BytesPerEntry, Equals, 0.0)
+
+	for i := 0; i < KEY_COUNT; i++ {
+		err = h.Insert(bKeys[i], rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	st = h.Stats()
+	c.Assert(st.W, Equals, w)
+	c.Assert(st.T, Equals, t)
+	c.Assert(st.LeafCount, Equals, h.GetLeafCount())
+	c.Assert(st.TableCount, Equals, h.GetTableCount())
+	c.Assert(len(st.TableFill), Equals, (1<<w)+1)
+	c.Assert(st.TableFill[0], Equals, uint(0)) // no empty tables
+	c.Assert(st.TableFill[1] <= st.TableCount-1, Equals, true)
+
+	// the per-depth and per-fill counts add up
+	var leaves, tables, filled, slots uint
+	for d := 0; d < len(st.LeavesAtDepth); d++ {
+		leaves += st.LeavesAtDepth[d]
+		tables += st.TablesAtDepth[d]
+	}
+	for n := 0; n < len(st.TableFill); n++ {
+		filled += st.TableFill[n]
+		slots += uint(n) * st.TableFill[n]
+	}
+	c.Assert(leaves, Equals, st.LeafCount)
+	c.Assert(tables, Equals, st.TableCount)
+	c.Assert(filled, Equals, st.TableCount-1)
+	// every slot in a table and every used slot in the root holds either
+	// a leaf or a table, and every table but the root is in some slot
+	c.Assert(slots+st.RootLeaves+st.RootTables, Equals,
+		st.LeafCount+st.TableCount-1)
+	c.Assert(st.RootLeaves, Equals, st.LeavesAtDepth[0])
+	c.Assert(st.RootTables, Equals, st.TablesAtDepth[1])
+
+	c.Assert(st.MaxDepth, Equals, uint(len(st.LeavesAtDepth)-1))
+	c.Assert(st.AvgDepth <= float64(st.MaxDepth), Equals, true)
+	c.Assert(st.BytesPerEntry > 0, Equals, true)
+
+	report := st.String()
+	c.Assert(strings.Contains(report,
+		fmt.Sprintf("%d leaves, %d tables", st.LeafCount, st.TableCount)),
+		Equals, true)
+}
+
+// Keys sharing long hashcode prefixes build a chain of single-entry
+// tables, one per level.
+func (s *XLSuite) TestStatsOnLongPaths(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_STATS_ON_LONG_PATHS")
+	}
+	rng := xr.MakeSimpleRNG()
+	w := uint(5)
+	_, rawKeys := s.makePermutedKeys(rng, w)
+	KEY_COUNT := 64 / w
+
+	h, err := NewHAMT(w, w)
+	c.Assert(err, IsNil)
+	for i := uint(0); i < KEY_COUNT; i++ {
+		bKey, err := NewBytesKey(rawKeys[i])
+		c.Assert(err, IsNil)
+		err = h.Insert(bKey, &rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	st := h.Stats()
+	c.Assert(st.MaxDepth, Equals, KEY_COUNT-1)
+	c.Assert(st.RootTables, Equals, uint(1))
+	c.Assert(st.RootLeaves, Equals, uint(0))
+	// every table but the deepest holds one leaf and one table; the
+	// deepest holds two leaves
+	c.Assert(st.TableFill[2], Equals, KEY_COUNT-1)
+	for d := uint(1); d < KEY_COUNT; d++ {
+		c.Assert(st.TablesAtDepth[d], Equals, uint(1))
+		if d < KEY_COUNT-1 {
+			c.Assert(st.LeavesAtDepth[d], Equals, uint(1))
+		} else {
+			c.Assert(st.LeavesAtDepth[d], Equals, uint(2))
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package hamt_go
+
+// hamt_go/stringKey.go
+
+// A StringKey is a string used as a key.  Unlike a BytesKey, whose
+// hashcode is its first 8 bytes, a StringKey's hashcode depends upon
+// every byte of the string, and keys of any length, including the empty
+// string, are allowed.  Keys are compared as strings.
+//
+// Converting a string to a StringKey and then to a KeyI allocates when
+// the KeyI escapes, as it does when passed to Find; use FindString to
+// look up a string without allocating.
+type StringKey string
+
+const (
+	fnvOffset64 = uint64(14695981039346656037)
+	fnvPrime64  = uint64(1099511628211)
+)
+
+// KeyI interface ///////////////////////////////////////////////////
+
+// Return the 64-bit FNV-1a hash of the string, with its bits mixed so
+// that every bit of the hashcode depends upon every byte of the key.
+// The string is not converted to a []byte, so this allocates nothing.
+func (s StringKey) Hashcode() uint64 {
+	hc := fnvOffset64
+	for i := 0; i < len(s); i++ {
+		hc ^= uint64(s[i])
+		hc *= fnvPrime64
+	}
+	return mix64(hc)
+}
+
+// Serialization ////////////////////////////////////////////////////
+
+// Encode the key as the bytes of the string.
+func (s StringKey) MarshalBinary() ([]byte, error) {
+	return []byte(s), nil
+}
+
+func (s *StringKey) UnmarshalBinary(data []byte) error {
+	*s = StringKey(data)
+	return nil
+}
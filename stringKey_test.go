@@ -0,0 +1,95 @@
+package hamt_go
+
+// hamt_go/stringKey_test.go
+
+import (
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestStringKeyHashcode(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_STRING_KEY_HASHCODE")
+	}
+	// every byte counts, not just the first 8
+	c.Assert(StringKey("abcdefgh1").Hashcode(), Not(Equals),
+		StringKey("abcdefgh2").Hashcode())
+	c.Assert(StringKey("").Hashcode(), Not(Equals), StringKey("a").Hashcode())
+	c.Assert(StringKey("key").Hashcode(), Equals, StringKey("key").Hashcode())
+
+	// keys of different types never match
+	c.Assert(sameKey(StringKey("abcdefgh"), StringKey("abcdefgh")), Equals, true)
+	c.Assert(sameKey(StringKey("abcdefgh"), BytesKey{[]byte("abcdefgh")}),
+		Equals, false)
+}
+
+func (s *XLSuite) TestStringKeyHAMT(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_STRING_KEY_HAMT")
+	}
+	const KEY_COUNT = 4096
+	rng := xr.MakeSimpleRNG()
+	keys := make([]string, KEY_COUNT)
+	seen := make(map[string]bool)
+	for i := 0; i < KEY_COUNT; i++ {
+		// short keys, and long keys sharing a prefix
+		k := fmt.Sprintf("%d", i)
+		if i%2 == 1 {
+			k = fmt.Sprintf("common/prefix/%d", rng.Int63())
+		}
+		for seen[k] {
+			k = fmt.Sprintf("common/prefix/%d", rng.Int63())
+		}
+		seen[k] = true
+		keys[i] = k
+	}
+	h, err := NewHAMT(5, 8)
+	c.Assert(err, IsNil)
+	a, err := NewArenaHAMT(5, 8)
+	c.Assert(err, IsNil)
+	c.Assert(h.Insert(StringKey(""), "empty"), IsNil)
+	c.Assert(a.Insert(StringKey(""), "empty"), IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Insert(StringKey(keys[i]), i), IsNil)
+		c.Assert(a.Insert(StringKey(keys[i]), i), IsNil)
+	}
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT+1))
+	c.Assert(len(h.Validate()), Equals, 0)
+	c.Assert(a.GetTableCount(), Equals, h.GetTableCount())
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := h.Find(StringKey(keys[i]))
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+		value, err = h.FindString(keys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+		value, err = a.Find(StringKey(keys[i]))
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+	value, err := h.FindString("")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "empty")
+	value, err = h.FindString("not a key")
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	allocs := testing.AllocsPerRun(10, func() {
+		for i := 0; i < KEY_COUNT; i++ {
+			h.FindString(keys[i])
+		}
+	})
+	c.Assert(allocs, Equals, float64(0))
+
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Delete(StringKey(keys[i])), IsNil)
+		c.Assert(a.Delete(StringKey(keys[i])), IsNil)
+	}
+	c.Assert(h.Delete(StringKey(keys[0])), Equals, NotFound)
+	c.Assert(h.GetLeafCount(), Equals, uint(1))
+	c.Assert(a.GetLeafCount(), Equals, uint(1))
+}
@@ -3,25 +3,45 @@ package hamt_go
 // hamt_go/table.go
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
-	xu "github.com/jddixon/xlUtil_go"
+	"math/bits"
 )
 
 var _ = fmt.Print
 
 // This is a non-root table; depth is guaranteed never to be zero.  We
-// use a uint64 as a bitmap, with a bit being set representing the fact
-// that a slot is in use, so there may not be more than 64 slots, so
-// w may not exceed 6 (2^6==64).
+// use uint64s as bitmaps, with a bit being set representing the fact
+// that a slot is in use.  A table with more than 64 slots, with w of 7
+// or 8, keeps the bits for slots 64 and up in wideMaps; see bitmap.go.
+//
+// The table is laid out as in CHAMP (Steindorfer and Vinju, "Optimizing
+// Hash-Array Mapped Tries for Fast and Lean Immutable JVM Collections",
+// 2015).  There are two bitmaps, one for slots holding entries and one
+// for slots holding subtables, and no bit is set in both.  The entries
+// and the subtables are kept separately, each in slot order, so that
+// the kind of a slot is known from the bitmaps without calling through
+// an interface, and so that a table's entries can be visited without
+// touching its subtables.
+//
+// Entries are held inline in parallel slices of keys and values rather
+// than as pointers to Leafs, so that inserting into a table allocates
+// no Leaf and finding a key follows no pointer to one.
 type Table struct {
-	w      uint // non-root tables have 2^w slots
-	t      uint // root table has 2^t slots
-	mask   uint64
-	bitmap uint64
-	slots  []HTNodeI // each nil or a pointer to either a leaf or a table
-	root   *Root     // pointer to the fixed-size root table
+	w        uint8         // non-root tables have 2^w slots
+	mask     uint8         // 2^w - 1, or zero in a leaf page
+	skip     uint8         // levels skipped by a compressed path; see paths.go
+	skipBits uint8         // hashcode bits used by the levels skipped
+	prefix   uint64        // value of those bits for every key below
+	dataMap  uint64        // bit n set if slot n < 64 holds an entry
+	nodeMap  uint64        // bit n set if slot n < 64 holds a subtable
+	wide     *wideMaps     // slots 64 and up; nil unless w > 6
+	keys     []KeyI        // one per bit in dataMap, in slot order
+	ukeys    []uint64      // in place of keys in a Uint64Map
+	values   []interface{} // parallel to keys
+	digests  [][]byte      // parallel to keys; nil unless Merkle HAMT
+	nodes    []*Table      // one per bit in nodeMap, in slot order
+	root     *Root         // pointer to the fixed-size root table
+	digest   []byte        // nil unless the HAMT is a Merkle HAMT
 }
 
 // Debugging / sanity check
@@ -29,26 +49,179 @@ func CheckTableParam(depth uint, root *Root) (w, t uint, err error) {
 	if root == nil {
 		err = NilRoot
 	} else {
-		w = root.w
 		t = root.t
-		if w > MAX_W {
-			err = MaxTableSizeExceeded
-		} else if t+(depth-1)*w > 64 {
+		if depth == 0 || depth > root.maxTableDepth {
 			err = MaxTableDepthExceeded
+		} else {
+			w = root.widths[depth]
 		}
 	}
 	return
 }
 
+// Most Tables are created when an entry is split from another, and
+// most of those never hold more than the two entries: with 2^20 keys,
+// w of 6 and t of 16, 57% of all Tables.  As the Adaptive Radix Tree
+// (Leis, Kemper and Neumann, 2013) starts each node as its smallest
+// kind, a Table created to hold entries starts out as a smallTable,
+// with room for two entries allocated along with it, so that creating
+// it and filling it takes one allocation rather than five.
+const smallTableEntries = 2
+
+type smallTable struct {
+	Table
+	keyBuf   [smallTableEntries]KeyI
+	valueBuf [smallTableEntries]interface{}
+}
+
+// As smallTable, for a Uint64Map.
+type smallUint64Table struct {
+	Table
+	ukeyBuf  [smallTableEntries]uint64
+	valueBuf [smallTableEntries]interface{}
+}
+
+// A Table which fills its room for entries moves them to the next
+// larger kind of entry block, with room for twice as many: 4, 8, 16,
+// 32, and then 64, the most a table with w of 6 can hold.  Keys and
+// values are allocated together in the block, in one allocation of
+// exactly a Go size class, rather than as two slices each grown on its
+// own.  Wider tables go on doubling in slices of their own.
+const minEntryBlock = 4
+
+type (
+	entries4 struct {
+		keys   [4]KeyI
+		values [4]interface{}
+	}
+	entries8 struct {
+		keys   [8]KeyI
+		values [8]interface{}
+	}
+	entries16 struct {
+		keys   [16]KeyI
+		values [16]interface{}
+	}
+	entries32 struct {
+		keys   [32]KeyI
+		values [32]interface{}
+	}
+	entries64 struct {
+		keys   [64]KeyI
+		values [64]interface{}
+	}
+)
+
+// As the entry blocks, for a Uint64Map.
+type (
+	uint64Entries4 struct {
+		ukeys  [4]uint64
+		values [4]interface{}
+	}
+	uint64Entries8 struct {
+		ukeys  [8]uint64
+		values [8]interface{}
+	}
+	uint64Entries16 struct {
+		ukeys  [16]uint64
+		values [16]interface{}
+	}
+	uint64Entries32 struct {
+		ukeys  [32]uint64
+		values [32]interface{}
+	}
+	uint64Entries64 struct {
+		ukeys  [64]uint64
+		values [64]interface{}
+	}
+)
+
+// Move the table's entries to the next larger entry block.  Called
+// only when the table has no room for another entry.
+func (table *Table) growEntries() {
+	n := minEntryBlock
+	for n <= cap(table.values) {
+		n *= 2
+	}
+	var values []interface{}
+	if table.root.uint64Keys {
+		var ukeys []uint64
+		switch n {
+		case 4:
+			b := new(uint64Entries4)
+			ukeys, values = b.ukeys[:0], b.values[:0]
+		case 8:
+			b := new(uint64Entries8)
+			ukeys, values = b.ukeys[:0], b.values[:0]
+		case 16:
+			b := new(uint64Entries16)
+			ukeys, values = b.ukeys[:0], b.values[:0]
+		case 32:
+			b := new(uint64Entries32)
+			ukeys, values = b.ukeys[:0], b.values[:0]
+		case 64:
+			b := new(uint64Entries64)
+			ukeys, values = b.ukeys[:0], b.values[:0]
+		default:
+			ukeys, values = make([]uint64, 0, n), make([]interface{}, 0, n)
+		}
+		table.ukeys = append(ukeys, table.ukeys...)
+	} else {
+		var keys []KeyI
+		switch n {
+		case 4:
+			b := new(entries4)
+			keys, values = b.keys[:0], b.values[:0]
+		case 8:
+			b := new(entries8)
+			keys, values = b.keys[:0], b.values[:0]
+		case 16:
+			b := new(entries16)
+			keys, values = b.keys[:0], b.values[:0]
+		case 32:
+			b := new(entries32)
+			keys, values = b.keys[:0], b.values[:0]
+		case 64:
+			b := new(entries64)
+			keys, values = b.keys[:0], b.values[:0]
+		default:
+			keys, values = make([]KeyI, 0, n), make([]interface{}, 0, n)
+		}
+		table.keys = append(keys, table.keys...)
+	}
+	table.values = append(values, table.values...)
+}
+
 func NewTable(depth uint, root *Root) (table *Table, err error) {
-	w, t, err := CheckTableParam(depth, root)
+	return newTable(depth, root, false)
+}
+
+// Create an empty table, as a smallTable if small and w <= 6.
+func newTable(depth uint, root *Root, small bool) (table *Table, err error) {
+	w, _, err := CheckTableParam(depth, root)
 	if err == nil {
-		table = new(Table)
-		table.w = w
-		table.t = t
+		if w > maxWordW {
+			// allocate the wideMaps with the Table, so that they
+			// share its cache lines
+			wt := new(wideTable)
+			table = &wt.Table
+			table.wide = &wt.wideMaps
+		} else if small && root.uint64Keys {
+			st := new(smallUint64Table)
+			table = &st.Table
+			table.ukeys = st.ukeyBuf[:0]
+			table.values = st.valueBuf[:0]
+		} else if small {
+			st := new(smallTable)
+			table = &st.Table
+			table.keys = st.keyBuf[:0]
+			table.values = st.valueBuf[:0]
+		} else {
+			table = new(Table)
+		}
+		table.w = uint8(w)
+		table.mask = uint8(1<<w - 1)
 		table.root = root
-		flag := uint64(1 << w)
-		table.mask = flag - 1
 	}
 	return
 }
@@ -58,23 +231,19 @@ func NewTable(depth uint, root *Root) (table *Table, err error) {
 func NewTableWithLeaf(depth uint, root *Root, firstLeaf *Leaf) (
 	table *Table, err error) {
 
-	w, t, err := CheckTableParam(depth, root)
+	return newTableWithEntry(depth, root,
+		firstLeaf.Key, firstLeaf.Value, firstLeaf.digest)
+}
+
+// Create a new table holding a single entry.
+func newTableWithEntry(depth uint, root *Root, key KeyI, value interface{},
+	digest []byte) (table *Table, err error) {
+
+	table, err = newTable(depth, root, true)
 	if err == nil {
-		tbl := new(Table)
-		tbl.w = w
-		tbl.t = t
-		tbl.root = root
-		wFlag := uint64(1 << w)
-		tbl.mask = wFlag - 1
-		shiftCount := t + (depth-1)*w
-		hc := firstLeaf.Key.Hashcode() >> shiftCount
-		ndx := hc & tbl.mask
-		flag := uint64(1 << ndx)
-		tbl.slots = []HTNodeI{firstLeaf}
-		tbl.bitmap = flag
-		if err == nil {
-			table = tbl
-		}
+		hc := root.hash(key) >> root.shifts[depth]
+		table.setData(hc & uint64(table.mask))
+		table.insertEntry(0, key, value, digest)
 	}
 	return
 }
@@ -88,31 +257,156 @@ func (table *Table) MaxSlots() uint {
 	return 1 << table.w
 }
 
+// Return whether slot ndx holds an entry and the offset of the entry,
+// or where it would go, in the entry slices.
+func (table *Table) dataSlot(ndx uint64) (uint, bool) {
+	if ndx < 64 {
+		flag := uint64(1) << ndx
+		return uint(bits.OnesCount64(table.dataMap & (flag - 1))),
+			table.dataMap&flag != 0
+	}
+	if table.wide == nil {
+		return 0, false // no such slot
+	}
+	return table.wide.data.slot(ndx)
+}
+
+// Return whether slot ndx holds a subtable and the offset of the
+// subtable, or where it would go, in nodes.
+func (table *Table) nodeSlot(ndx uint64) (uint, bool) {
+	if ndx < 64 {
+		flag := uint64(1) << ndx
+		return uint(bits.OnesCount64(table.nodeMap & (flag - 1))),
+			table.nodeMap&flag != 0
+	}
+	if table.wide == nil {
+		return 0, false // no such slot
+	}
+	return table.wide.node.slot(ndx)
+}
+
+// Return the bitmap words holding the bits for slot ndx, and the
+// number of bits set in all lower words: dataSlot and nodeSlot in one,
+// in a form that the lookup loops can inline.
+func (table *Table) slotWords(ndx uint64) (dataMap, nodeMap uint64,
+	dataBase, nodeBase uint) {
+
+	if ndx < 64 {
+		return table.dataMap, table.nodeMap, 0, 0
+	}
+	return table.wide.words(ndx)
+}
+
+// Mark slot ndx as holding an entry.
+func (table *Table) setData(ndx uint64) {
+	if table.wide == nil {
+		table.dataMap |= uint64(1) << ndx
+	} else {
+		table.wide.data.change(&table.dataMap, ndx, 1)
+	}
+}
+
+// Mark slot ndx as not holding an entry.
+func (table *Table) clearData(ndx uint64) {
+	if table.wide == nil {
+		table.dataMap &= ^(uint64(1) << ndx)
+	} else {
+		table.wide.data.change(&table.dataMap, ndx, -1)
+	}
+}
+
+// Mark slot ndx as holding a subtable.
+func (table *Table) setNode(ndx uint64) {
+	if table.wide == nil {
+		table.nodeMap |= uint64(1) << ndx
+	} else {
+		table.wide.node.change(&table.nodeMap, ndx, 1)
+	}
+}
+
+// Mark slot ndx as not holding a subtable.
+func (table *Table) clearNode(ndx uint64) {
+	if table.wide == nil {
+		table.nodeMap &= ^(uint64(1) << ndx)
+	} else {
+		table.wide.node.change(&table.nodeMap, ndx, -1)
+	}
+}
+
+// Return the entry and subtable bitmaps, one word per 64 slots, of
+// which the first n words are used.
+func (table *Table) bitmaps() (dataMap, nodeMap [maxBitmapWords]uint64, n int) {
+	dataMap[0], nodeMap[0] = table.dataMap, table.nodeMap
+	if table.wide != nil {
+		copy(dataMap[1:], table.wide.data.words[:])
+		copy(nodeMap[1:], table.wide.node.words[:])
+	}
+	return dataMap, nodeMap, bitmapWordCount(uint(table.w))
+}
+
+// Return a bitmap with a bit set for every slot in use, one word per
+// 64 slots.
+func (table *Table) bitmap() []uint64 {
+	dataMap, nodeMap, n := table.bitmaps()
+	bitmap := make([]uint64, n)
+	for i := range bitmap {
+		bitmap[i] = dataMap[i] | nodeMap[i]
+	}
+	return bitmap
+}
+
+// Return the number of slots in use.
+func (table *Table) usedSlots() uint {
+	return uint(len(table.values) + len(table.nodes))
+}
+
+// Return the key of the entry at offset in the entry slices.  In a
+// Uint64Map this boxes the key, so it is for use off the hot paths only.
+func (table *Table) keyAt(offset int) KeyI {
+	if table.root.uint64Keys {
+		return Uint64Key(table.ukeys[offset])
+	}
+	return table.keys[offset]
+}
+
+// Return the entry at offset in the entry slices as a Leaf.  This
+// allocates, so it is for use off the hot paths only.
+func (table *Table) leafAt(offset int) *Leaf {
+	leaf := &Leaf{Key: table.keyAt(offset), Value: table.values[offset]}
+	if table.digests != nil {
+		leaf.digest = table.digests[offset]
+	}
+	return leaf
+}
+
+// Return the child in the slot with index ndx, or nil if that slot is
+// not in use.  An entry is returned as a newly allocated Leaf.
+func (table *Table) childAt(ndx uint64) (node HTNodeI) {
+	if offset, ok := table.dataSlot(ndx); ok {
+		node = table.leafAt(int(offset))
+	} else if offset, ok := table.nodeSlot(ndx); ok {
+		node = table.nodes[offset]
+	}
+	return
+}
+
 // Return a count of leaf nodes in this table.
 func (table *Table) getLeafCount() (count uint) {
-	for i := 0; i < len(table.slots); i++ {
-		node := table.slots[i]
-		if node != nil {
-			if node.IsLeaf() {
-				count++
-			} else {
-				tDeeper := node.(*Table)
-				count += tDeeper.getLeafCount()
-			}
-		}
+	count = uint(len(table.values))
+	for i := 0; i < len(table.nodes); i++ {
+		count += table.nodes[i].getLeafCount()
 	}
 	return
 }
 
-//
+// Return a count of this table and those below it, not counting pages.
 func (table *Table) getTableCount() (count uint) {
+	if table.isPage() {
+		return 0
+	}
 	count = 1
-	for i := 0; i < len(table.slots); i++ {
-		node := table.slots[i]
-		if node != nil && !node.IsLeaf() {
-			tDeeper := node.(*Table)
-			count += tDeeper.getTableCount()
-		}
+	for i := 0; i < len(table.nodes); i++ {
+		count += table.nodes[i].getTableCount()
 	}
 	return
 }
@@ -121,33 +415,67 @@ func (table *Table) getTableCount() (count uint) {
 //	return uint(table.depth)
 //}
 
-// XXX Should modify to remove empty table recursively where this
-// is the last entry in the table
-func (table *Table) removeFromSlices(offset uint) (err error) {
-	curSize := uint(len(table.slots))
-	if curSize == 0 {
-		err = DeleteFromEmptyTable
-	} else if offset >= curSize {
-		err = errors.New(fmt.Sprintf(
-			"InternalError: delete offset %d but table size %d\n",
-			offset, curSize))
-	} else if curSize == 1 {
-		// XXX LEAVES EMPTY TABLE, WHICH WILL HARM PERFORMANCE
-		table.slots = table.slots[0:0]
-	} else if offset == 0 {
-		table.slots = table.slots[1:]
-	} else if offset == curSize-1 {
-		table.slots = table.slots[0:offset]
+// 2014-05-13: Performance was considerably improved (runtime down
+// 25-50%) by replacing slice appends with slice make/copy sequences.
+// Now that each table holds several parallel slices, these are grown
+// geometrically instead, so that an insert into a table usually
+// allocates nothing; removals close the gap in place.
+
+// Return s with v inserted at offset.
+func insertAt[T any](s []T, offset uint, v T) []T {
+	if len(s) < cap(s) {
+		s = s[:len(s)+1]
+		copy(s[offset+1:], s[offset:])
 	} else {
-		// Get rid of expensive appends.
-		// shorterSlots := table.slots[0:offset]
-		// shorterSlots = append(shorterSlots, table.slots[offset+1:]...)
-		shorterSlots := make([]HTNodeI, curSize-1)
-		copy(shorterSlots[0:offset], table.slots[0:offset])
-		copy(shorterSlots[offset:], table.slots[offset+1:])
-		table.slots = shorterSlots
+		longer := make([]T, len(s)+1, 2*len(s)+1)
+		copy(longer[:offset], s[:offset])
+		copy(longer[offset+1:], s[offset:])
+		s = longer
+	}
+	s[offset] = v
+	return s
+}
+
+// Return s with the element at offset removed.
+func removeAt[T any](s []T, offset uint) []T {
+	last := len(s) - 1
+	copy(s[offset:], s[offset+1:])
+	var zero T
+	s[last] = zero // let the garbage collector have it
+	return s[:last]
+}
+
+// Insert an entry at offset in the entry slices.  The caller sets the
+// slot's bit with setData.
+func (table *Table) insertEntry(offset uint, key KeyI, value interface{},
+	digest []byte) {
+
+	if len(table.values) == cap(table.values) {
+		table.growEntries()
+	}
+	if table.root.uint64Keys {
+		table.ukeys = insertAt(table.ukeys, offset, uint64(key.(Uint64Key)))
+	} else {
+		table.keys = insertAt(table.keys, offset, key)
+	}
+	table.values = insertAt(table.values, offset, value)
+	if table.root.merkle {
+		table.digests = insertAt(table.digests, offset, digest)
+	}
+}
+
+// Remove the entry at offset from the entry slices.  The caller clears
+// the slot's bit with clearData.
+func (table *Table) removeEntry(offset uint) {
+	if table.root.uint64Keys {
+		table.ukeys = removeAt(table.ukeys, offset)
+	} else {
+		table.keys = removeAt(table.keys, offset)
+	}
+	table.values = removeAt(table.values, offset)
+	if table.root.merkle {
+		table.digests = removeAt(table.digests, offset)
 	}
-	return
 }
 
 // Enter with hc the hashcode for the key shifted appropriately for the
@@ -158,170 +486,237 @@ func (table *Table) removeFromSlices(offset uint) (err error) {
 func (table *Table) deleteLeaf(hc uint64, depth uint, key KeyI) (
 	err error) {
 
-	if len(table.slots) == 0 {
+	if table.isPage() {
 		err = NotFound
-	} else {
-		ndx := hc & table.mask
-		flag := uint64(1 << ndx)
-		mask := flag - 1
-		if table.bitmap&flag == 0 {
+		for i := 0; i < len(table.keys); i++ {
+			if sameKey(table.keys[i], key) {
+				table.removeEntry(uint(i))
+				err = nil
+				break
+			}
+		}
+		return
+	}
+	if table.skip != 0 {
+		if hc&(uint64(1)<<table.skipBits-1) != table.prefix {
+			return NotFound
+		}
+		hc >>= table.skipBits
+		depth += uint(table.skip)
+	}
+	ndx := hc & uint64(table.mask)
+	if slotNbr, ok := table.dataSlot(ndx); ok {
+		// there is an entry in the slot
+		if sameKey(table.keyAt(int(slotNbr)), key) {
+			table.removeEntry(slotNbr)
+			table.clearData(ndx)
+		} else {
+			err = NotFound
+		}
+	} else if slotNbr, ok := table.nodeSlot(ndx); ok {
+		// the slot holds a table, so recurse
+		depth++
+		if depth > table.root.maxTableDepth && !table.root.buckets {
 			err = NotFound
 		} else {
-			// the node is present; get its position in the slice
-			var slotNbr uint
-			if mask != 0 {
-				slotNbr = xu.BitCount64(table.bitmap & mask)
-			}
-			node := table.slots[slotNbr]
-			if node.IsLeaf() {
-				myLeaf := node.(*Leaf)
-				myKey := myLeaf.Key.(BytesKey)
-				searchKey := key.(BytesKey)
-				if bytes.Equal(searchKey.Slice, myKey.Slice) {
-					err = table.removeFromSlices(slotNbr)
-					table.bitmap &= ^flag
-				} else {
-					err = NotFound
-				}
-			} else {
-				// node is a table, so recurse
-				depth++
-				if depth > table.root.maxTableDepth {
-					err = NotFound
-				} else {
-					tDeeper := node.(*Table)
-					hc >>= table.w
-					err = tDeeper.deleteLeaf(hc, depth, key)
-				}
+			tDeeper := table.nodes[slotNbr]
+			hc >>= table.w
+			err = tDeeper.deleteLeaf(hc, depth, key)
+			if err == nil {
+				table.pruneSlot(slotNbr, ndx, tDeeper, depth)
 			}
 		}
+	} else {
+		err = NotFound
+	}
+	if err == nil && table.root.merkle && table.usedSlots() > 0 {
+		table.digest = table.calcDigest()
 	}
 	return
 }
 
+// Called after a deletion from tDeeper, the table at depth in slot ndx
+// and at offset slotNbr in nodes, to keep the trie in canonical form: a
+// table which has been left empty is removed, a table left holding a
+// single entry is replaced by that entry, in a HAMT with pages, a
+// table left with no more than a page's worth of entries below it is
+// replaced by a page, and in a HAMT with compressed paths, a table left
+// holding a single subtable is joined to it.  The trie then has the
+// same shape as one built by inserting only the remaining keys; in
+// particular, a Merkle HAMT's digest depends only upon its contents.
+func (table *Table) pruneSlot(slotNbr uint, ndx uint64, tDeeper *Table,
+	depth uint) {
+
+	if len(tDeeper.nodes) == 0 && len(tDeeper.values) <= 1 {
+		table.nodes = removeAt(table.nodes, slotNbr)
+		table.clearNode(ndx)
+		if len(tDeeper.values) == 1 {
+			var digest []byte
+			if tDeeper.digests != nil {
+				digest = tDeeper.digests[0]
+			}
+			offset, _ := table.dataSlot(ndx)
+			table.insertEntry(offset, tDeeper.keyAt(0), tDeeper.values[0], digest)
+			table.setData(ndx)
+		}
+	} else if page := tDeeper.pageIfSmall(depth); page != nil {
+		table.nodes[slotNbr] = page
+	} else if child := tDeeper.joinPath(); child != nil {
+		table.nodes[slotNbr] = child
+	}
+}
+
 // Enter with hc the hashcode for the key shifted appropriately for the
 // current depth, the depth as a zero-based integer, and the full key.
 // Return nil if no matching entry is found or the value associated with
 // the matching entry or any error encountered.
 //
+// This is a single loop down through the subtables, with hc shifted
+// along the way, rather than a recursive call for each level.
+//
 // The caller guarantees that depth<=Root.maxTableDepth.
 //
 func (table *Table) findLeaf(hc uint64, depth uint, key KeyI) (
-	value interface{}, err error) { // 1 of 90 samples cum
-
-	ndx := hc & table.mask // 27 of 52; MOVQ 10(DX),CX
-	flag := uint64(1 << ndx)
+	value interface{}, err error) {
 
-	if table.bitmap&flag != 0 {
-		// the node is present; get its position in the slice
-		var slotNbr uint
-		mask := flag - 1
-		if mask != 0 {
-			slotNbr = uint(xu.BitCount64(table.bitmap & mask)) // gets expanded inline; 0/52
+	maxDepth := table.root.maxTableDepth
+	for {
+		if table.skip != 0 {
+			// a compressed path; see paths.go
+			if hc&(uint64(1)<<table.skipBits-1) != table.prefix {
+				return // the value returned is nil
+			}
+			hc >>= table.skipBits
+			depth += uint(table.skip)
 		}
-		node := table.slots[slotNbr] // 20 of 52 - ADDQ BP,BX
-		if node.IsLeaf() {
-			myLeaf := node.(*Leaf)
-			myKey := myLeaf.Key.(BytesKey) // 5 of 92 cum - lib call assest
-			searchKey := key.(BytesKey)    // 7 of 92 cum - lib call assert
-			if bytes.Equal(searchKey.Slice, myKey.Slice) {
-				value = myLeaf.Value
+		ndx := hc & uint64(table.mask)
+		dataMap, nodeMap, dataBase, nodeBase := table.slotWords(ndx)
+		flag := uint64(1) << (ndx & 63)
+		if dataMap&flag != 0 {
+			// there is an entry in the slot; get its position in the slices
+			slotNbr := dataBase + uint(bits.OnesCount64(dataMap&(flag-1)))
+			if sameKey(table.keys[slotNbr], key) {
+				value = table.values[slotNbr]
 			}
 			// otherwise the value returned is nil
-		} else {
-			// node is a table, so recurse
-			depth++
-			if depth <= table.root.maxTableDepth {
-				tDeeper := node.(*Table)
-				hc >>= table.w
-				value, err = tDeeper.findLeaf(hc, depth, key)
+			return
+		}
+		depth++
+		if nodeMap&flag == 0 || depth > maxDepth {
+			if table.isPage() {
+				value = table.findInPage(key)
+			} else if nodeMap&flag != 0 {
+				// a collision bucket below the deepest table
+				bucket := table.nodes[nodeBase+uint(bits.OnesCount64(nodeMap&(flag-1)))]
+				value = bucket.findInPage(key)
 			}
-			// otherwise the value returned is nil
+			return // otherwise the value returned is nil
 		}
+		// the slot holds a table, so descend
+		hc >>= table.w
+		table = table.nodes[nodeBase+uint(bits.OnesCount64(nodeMap&(flag-1)))]
 	}
-	return
 }
 
 // Enter with hc having been shifted so that the first w bits are ndx.
-// 2014-05-13: Performance of this function was considerably improved (runtime
-// down 25-50%) by replacing slice appends with slice make/copy sequences.
+// digest is the entry's digest in a Merkle HAMT and otherwise nil.
+//
+// Like findLeaf, this loops down through the subtables.  In a Merkle
+// HAMT the tables passed through are remembered, so that their digests
+// can be recalculated from the bottom up once the entry is in place.
 //
 // The caller guarantees that depth <= Root.maxTableDepth.
-func (table *Table) insertLeaf(hc uint64, depth uint, leaf *Leaf) (err error) {
-
-	var slotNbr uint // whatever is in first line: about 15 of 37
-	ndx := hc & table.mask
-	flag := uint64(1 << ndx)
-	mask := flag - 1
-	if mask != 0 {
-		slotNbr = xu.BitCount64(table.bitmap & mask)
-	}
-	sliceSize := uint(len(table.slots))
-	if sliceSize == 0 {
-		table.slots = []HTNodeI{leaf}
-		table.bitmap |= flag
-	} else {
-		// Is there is already something at this slotNbr ?
-		if table.bitmap&flag != 0 {
-			entry := table.slots[slotNbr]
-
-			if entry.IsLeaf() {
-				// if it's a leaf, we replace the value iff the keys match
-				curLeaf := entry.(*Leaf)
-				curKey := curLeaf.Key.(BytesKey)
-				newKey := leaf.Key.(BytesKey)
-				if bytes.Equal(curKey.Slice, newKey.Slice) {
-					// the keys match, so we replace the value
-					curLeaf.Value = leaf.Value
-				} else {
-					var (
-						tableDeeper *Table
-					)
-					depth++
-					if depth > table.root.maxTableDepth {
-						err = MaxTableDepthExceeded
-					} else {
-						oldLeaf := entry.(*Leaf)
-						tableDeeper, err = NewTableWithLeaf(
-							depth, table.root, oldLeaf)
-						if err == nil {
-							hc >>= table.w // this is hashcode for the NEW leaf
-							// then put the new leaf in the new table
-							err = tableDeeper.insertLeaf(hc, depth, leaf)
-							if err == nil {
-								// the new table replaces the existing leaf
-								table.slots[slotNbr] = tableDeeper
-							}
-						}
-					}
-				}
-			} else {
-				depth++
-				if depth > table.root.maxTableDepth {
-					err = MaxTableDepthExceeded
-				} else {
-					// otherwise it's a table, so recurse
-					tDeeper := entry.(*Table)
-					hc >>= table.w
-					err = tDeeper.insertLeaf(hc, depth, leaf)
-				}
+func (table *Table) insertLeaf(hc uint64, depth uint, key KeyI,
+	value interface{}, digest []byte) (err error) {
+
+	root := table.root
+	var path []*Table // tables whose digests change; Merkle only
+	for {
+		if root.merkle {
+			path = append(path, table)
+		} else if table.isPage() {
+			err = table.insertIntoPage(depth, key, value)
+			break
+		}
+		if table.skip != 0 {
+			if hc&(uint64(1)<<table.skipBits-1) != table.prefix {
+				err = table.splitPath(hc, depth, key, value)
+				break
+			}
+			hc >>= table.skipBits
+			depth += uint(table.skip)
+		}
+		ndx := hc & uint64(table.mask)
+		nodeNbr, ok := table.nodeSlot(ndx)
+		if ok {
+			depth++
+			if depth > root.maxTableDepth && !root.buckets {
+				err = MaxTableDepthExceeded
+				break
+			}
+			// it's a table or a collision bucket, so descend
+			hc >>= table.w
+			table = table.nodes[nodeNbr]
+			continue
+		}
+		dataNbr, ok := table.dataSlot(ndx)
+		if !ok {
+			// the slot is free
+			table.insertEntry(dataNbr, key, value, digest)
+			table.setData(ndx)
+			break
+		}
+		// if it's an entry, we replace the value iff the keys match
+		if sameKey(table.keys[dataNbr], key) {
+			// the keys match, so we replace the value
+			table.values[dataNbr] = value
+			if table.digests != nil {
+				table.digests[dataNbr] = digest
 			}
-		} else if slotNbr == 0 {
-			leftSlots := make([]HTNodeI, sliceSize+1)
-			leftSlots[0] = leaf
-			copy(leftSlots[1:], table.slots[:])
-			table.slots = leftSlots
-			table.bitmap |= flag
-		} else if slotNbr == sliceSize {
-			table.slots = append(table.slots, leaf)
-			table.bitmap |= flag
+			break
+		}
+		depth++
+		if depth > root.maxTableDepth && !root.buckets {
+			err = MaxTableDepthExceeded
+			break
+		}
+		var curDigest []byte
+		if table.digests != nil {
+			curDigest = table.digests[dataNbr]
+		}
+		var tableDeeper *Table
+		if root.pageSize > 1 || depth > root.maxTableDepth {
+			// the two entries go together in a page or collision bucket
+			tableDeeper = newPage(depth, root)
+			tableDeeper.keys = append(tableDeeper.keys, table.keys[dataNbr], key)
+			tableDeeper.values = append(tableDeeper.values, table.values[dataNbr], value)
 		} else {
-			leftSlots := make([]HTNodeI, sliceSize+1)
-			copy(leftSlots[:slotNbr], table.slots[:slotNbr])
-			leftSlots[slotNbr] = leaf
-			copy(leftSlots[slotNbr+1:], table.slots[slotNbr:])
-			table.slots = leftSlots
-			table.bitmap |= flag
+			if root.compressPaths {
+				// the new table skips any levels at which the keys agree
+				tableDeeper, err = newPathTable(depth, root, hc>>table.w,
+					table.keys[dataNbr], table.values[dataNbr])
+			} else {
+				tableDeeper, err = newTableWithEntry(depth, root,
+					table.keys[dataNbr], table.values[dataNbr], curDigest)
+			}
+			if err == nil {
+				// put the new entry in the new table; it recurses only
+				// if the two hashcodes agree at this depth too
+				err = tableDeeper.insertLeaf(hc>>table.w, depth, key, value, digest)
+			}
+		}
+		if err == nil {
+			// the new table replaces the existing entry
+			table.removeEntry(dataNbr)
+			table.clearData(ndx)
+			table.nodes = insertAt(table.nodes, nodeNbr, tableDeeper)
+			table.setNode(ndx)
+		}
+		break
+	}
+	if err == nil {
+		for i := len(path) - 1; i >= 0; i-- {
+			path[i].digest = path[i].calcDigest()
 		}
 	}
 	return
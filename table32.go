@@ -0,0 +1,184 @@
+package hamt_go
+
+// hamt_go/table32.go
+
+import (
+	"math/bits"
+)
+
+// A table below the root of a HAMT32; see hamt32.go.  It is laid out
+// as a Table is, with separate bitmaps for entries and subtables and
+// its entries held inline, but with uint32 bitmaps and without the
+// fields a Table keeps for wide tables, Uint64Maps, and Merkle HAMTs.
+// Its width is that the root gives for its depth.
+//
+// A table32 one deeper than root32.maxTableDepth is a collision
+// bucket: its bitmaps are zero, it has no subtables, and it holds two
+// or more entries whose hashcodes are the same, in no particular order.
+type table32 struct {
+	dataMap uint32        // bit n set if slot n holds an entry
+	nodeMap uint32        // bit n set if slot n holds a subtable
+	keys    []KeyI        // one per bit in dataMap, in slot order
+	values  []interface{} // parallel to keys
+	nodes   []*table32    // one per bit in nodeMap, in slot order
+}
+
+func (table *table32) IsLeaf() bool {
+	return false
+}
+
+// Create a table at the given depth holding a single entry.
+func (root *root32) newTable(depth uint, key KeyI, value interface{}) *table32 {
+	table := &table32{keys: []KeyI{key}, values: []interface{}{value}}
+	if depth <= root.maxTableDepth {
+		hc := hash32(key) >> root.shifts[depth]
+		table.dataMap = uint32(1) << (hc & (uint32(1)<<root.widths[depth] - 1))
+	}
+	return table
+}
+
+// Return a count of the entries in this table and those below it.
+func (table *table32) getLeafCount() (count uint) {
+	count = uint(len(table.values))
+	for i := 0; i < len(table.nodes); i++ {
+		count += table.nodes[i].getLeafCount()
+	}
+	return
+}
+
+// Return a count of this table and those below it.
+func (table *table32) getTableCount() (count uint) {
+	count = 1
+	for i := 0; i < len(table.nodes); i++ {
+		count += table.nodes[i].getTableCount()
+	}
+	return
+}
+
+// Enter with hc the key's hashcode shifted for the current depth.
+// Return the value associated with the key, or nil if there is none.
+// As Table.findLeaf, this loops down through the subtables, ending
+// with a search of any collision bucket reached.
+func (table *table32) findLeaf(root *root32, hc uint32, depth uint,
+	key KeyI) (value interface{}) {
+
+	for ; depth <= root.maxTableDepth; depth++ {
+		w := root.widths[depth]
+		flag := uint32(1) << (hc & (uint32(1)<<w - 1))
+		if table.dataMap&flag != 0 {
+			slotNbr := bits.OnesCount32(table.dataMap & (flag - 1))
+			if sameKey(table.keys[slotNbr], key) {
+				value = table.values[slotNbr]
+			}
+			return
+		}
+		if table.nodeMap&flag == 0 {
+			return
+		}
+		hc >>= w
+		table = table.nodes[bits.OnesCount32(table.nodeMap&(flag-1))]
+	}
+	for i := 0; i < len(table.keys); i++ {
+		if sameKey(table.keys[i], key) {
+			return table.values[i]
+		}
+	}
+	return
+}
+
+// Enter with hc the key's hashcode shifted for the current depth.
+// Insert the entry, replacing the value of any entry with the same key.
+func (table *table32) insertLeaf(root *root32, hc uint32, depth uint,
+	key KeyI, value interface{}) {
+
+	for ; depth <= root.maxTableDepth; depth++ {
+		w := root.widths[depth]
+		flag := uint32(1) << (hc & (uint32(1)<<w - 1))
+		nodeNbr := uint(bits.OnesCount32(table.nodeMap & (flag - 1)))
+		if table.nodeMap&flag != 0 {
+			// it's a table, so descend
+			hc >>= w
+			table = table.nodes[nodeNbr]
+			continue
+		}
+		dataNbr := uint(bits.OnesCount32(table.dataMap & (flag - 1)))
+		if table.dataMap&flag == 0 {
+			// the slot is free
+			table.keys = insertAt(table.keys, dataNbr, key)
+			table.values = insertAt(table.values, dataNbr, value)
+			table.dataMap |= flag
+			return
+		}
+		if sameKey(table.keys[dataNbr], key) {
+			table.values[dataNbr] = value
+			return
+		}
+		// move the existing entry into a new table, then add the new one
+		tableDeeper := root.newTable(depth+1, table.keys[dataNbr],
+			table.values[dataNbr])
+		tableDeeper.insertLeaf(root, hc>>w, depth+1, key, value)
+		table.keys = removeAt(table.keys, dataNbr)
+		table.values = removeAt(table.values, dataNbr)
+		table.dataMap &^= flag
+		table.nodes = insertAt(table.nodes, nodeNbr, tableDeeper)
+		table.nodeMap |= flag
+		return
+	}
+	// a collision bucket
+	for i := 0; i < len(table.keys); i++ {
+		if sameKey(table.keys[i], key) {
+			table.values[i] = value
+			return
+		}
+	}
+	table.keys = append(table.keys, key)
+	table.values = append(table.values, value)
+}
+
+// Enter with hc the key's hashcode shifted for the current depth.
+// Remove the entry with the key, returning NotFound if there is none.
+// As Table.pruneSlot does, a subtable left empty is removed and one
+// left holding a single entry is replaced by it.
+func (table *table32) deleteLeaf(root *root32, hc uint32, depth uint,
+	key KeyI) (err error) {
+
+	if depth > root.maxTableDepth {
+		// a collision bucket
+		for i := 0; i < len(table.keys); i++ {
+			if sameKey(table.keys[i], key) {
+				table.keys = removeAt(table.keys, uint(i))
+				table.values = removeAt(table.values, uint(i))
+				return
+			}
+		}
+		return NotFound
+	}
+	w := root.widths[depth]
+	flag := uint32(1) << (hc & (uint32(1)<<w - 1))
+	dataNbr := uint(bits.OnesCount32(table.dataMap & (flag - 1)))
+	if table.dataMap&flag != 0 {
+		if sameKey(table.keys[dataNbr], key) {
+			table.keys = removeAt(table.keys, dataNbr)
+			table.values = removeAt(table.values, dataNbr)
+			table.dataMap &^= flag
+		} else {
+			err = NotFound
+		}
+	} else if table.nodeMap&flag != 0 {
+		nodeNbr := uint(bits.OnesCount32(table.nodeMap & (flag - 1)))
+		tDeeper := table.nodes[nodeNbr]
+		err = tDeeper.deleteLeaf(root, hc>>w, depth+1, key)
+		if err == nil && len(tDeeper.nodes) == 0 && len(tDeeper.values) <= 1 {
+			table.nodes = removeAt(table.nodes, nodeNbr)
+			table.nodeMap &^= flag
+			if len(tDeeper.values) == 1 {
+				table.keys = insertAt(table.keys, dataNbr, tDeeper.keys[0])
+				table.values = insertAt(table.values, dataNbr, tDeeper.values[0])
+				table.dataMap |= flag
+			}
+		}
+	} else {
+		err = NotFound
+	}
+	return
+}
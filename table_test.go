@@ -6,8 +6,9 @@ import (
 	"bytes"
 	"fmt"
 	xr "github.com/jddixon/rnglib_go"
-	xu "github.com/jddixon/xlUtil_go"
 	. "gopkg.in/check.v1"
+	"math/bits"
+	"unsafe"
 )
 
 var _ = fmt.Print
@@ -31,7 +32,8 @@ func (s *XLSuite) doTestTableCtor(c *C, w uint) {
 	c.Assert(err, IsNil)
 	c.Assert(table, NotNil)
 	c.Assert(table.GetRoot(), Equals, dummyRoot)
-	c.Assert(table.slots, IsNil)
+	c.Assert(table.keys, IsNil)
+	c.Assert(table.nodes, IsNil)
 }
 
 // ------------------------------------------------------------------
@@ -79,7 +81,7 @@ func (s *XLSuite) doTestTableDepthZeroInserts(c *C, w, t uint) {
 	)
 	dummyRoot, err := NewRoot(w, t)
 	c.Assert(err, IsNil)
-	c.Assert(dummyRoot.maxTableDepth, Equals, (64-t)/w)
+	c.Assert(dummyRoot.maxTableDepth, Equals, (64-t+w-1)/w)
 
 	depth := uint(1)
 	SLOT_COUNT := uint(1 << w)
@@ -108,19 +110,19 @@ func (s *XLSuite) doTestTableDepthZeroInserts(c *C, w, t uint) {
 	c.Assert(bytes.Equal(rawKey, *p), Equals, true)
 
 	// check table attributes ---------------------------------------
-	c.Assert(table.w, Equals, w)
-	c.Assert(table.t, Equals, t)
+	c.Assert(uint(table.w), Equals, w)
+	c.Assert(table.root.t, Equals, t)
 	flag = uint64(1)
 	flag <<= (t + w)
 	expectedMask := flag - 1
-	c.Assert(table.mask, Equals, expectedMask)
+	c.Assert(uint64(table.mask), Equals, expectedMask)
 	c.Assert(table.MaxSlots(), Equals, SLOT_COUNT)
 
 	// verify bit mask is as expected, firstLeaf having been inserted
-	idx = hc & table.mask
+	idx = hc & uint64(table.mask)
 	flag = 1 << idx
 	mask = flag - 1
-	slotNbr := xu.BitCount64(bitmap & mask)
+	slotNbr := uint(bits.OnesCount64(bitmap & mask))
 	c.Assert(0 <= slotNbr && slotNbr < SLOT_COUNT, Equals, true)
 	occupied := uint64(1 << idx)
 	bitmap |= occupied
@@ -135,19 +137,20 @@ func (s *XLSuite) doTestTableDepthZeroInserts(c *C, w, t uint) {
 		c.Assert(err, IsNil)
 		c.Assert(value, IsNil)
 
-		err = table.insertLeaf(hc, depth, leaf)
+		err = table.insertLeaf(hc, depth, leaf.Key, leaf.Value, nil)
 		c.Assert(err, IsNil)
 
 		// insert the value into the hash slice in such a way as
 		// to maintain order
-		idx = hc & table.mask
+		idx = hc & uint64(table.mask)
 		flag = 1 << idx
 		mask = flag - 1
-		slotNbr := xu.BitCount64(bitmap & mask)
+		slotNbr := uint(bits.OnesCount64(bitmap & mask))
 		c.Assert(0 <= slotNbr && slotNbr < SLOT_COUNT, Equals, true)
 		occupied := uint64(1 << idx)
 		bitmap |= occupied
-		c.Assert(table.bitmap, Equals, bitmap)
+		c.Assert(table.dataMap, Equals, bitmap)
+		c.Assert(table.nodeMap, Equals, uint64(0))
 
 		v, err := table.findLeaf(hc, depth, bKey)
 		c.Assert(err, IsNil)
@@ -157,7 +160,7 @@ func (s *XLSuite) doTestTableDepthZeroInserts(c *C, w, t uint) {
 	}
 	// verify that the order of entries in the slots is as expected
 	// remove each key, then verify that it is in fact gone
-	c.Assert(uint(len(table.slots)), Equals, SLOT_COUNT)
+	c.Assert(table.usedSlots(), Equals, SLOT_COUNT)
 	for i := uint(0); i < SLOT_COUNT; i++ {
 		key := rawKeys[i]
 
@@ -200,9 +203,9 @@ func (s *XLSuite) TestEntrySplittingInserts(c *C) {
 	// s.doTestEntrySplittingInserts(c, rng, uint(3))	// key too short
 	// s.doTestEntrySplittingInserts(c, rng, uint(4))	// index out of range
 	s.doTestEntrySplittingInserts(c, rng, uint(5))
-	s.doTestEntrySplittingInserts(c, rng, uint(6)) // MAX_W
-	// s.doTestEntrySplittingInserts(c, rng, uint(7))	// entry not found
-	// s.doTestEntrySplittingInserts(c, rng, uint(8))	// index out of range
+	s.doTestEntrySplittingInserts(c, rng, uint(6))
+	s.doTestEntrySplittingInserts(c, rng, uint(7))
+	s.doTestEntrySplittingInserts(c, rng, uint(8)) // MAX_W
 }
 
 func (s *XLSuite) doTestEntrySplittingInserts(c *C, rng *xr.PRNG, w uint) {
@@ -218,13 +221,13 @@ func (s *XLSuite) doTestEntrySplittingInserts(c *C, rng *xr.PRNG, w uint) {
 	c.Assert(table, NotNil)
 	//c.Assert(table.GetDepth(), Equals, depth)
 
-	c.Assert(table.w, Equals, w)
-	c.Assert(table.t, Equals, t)
+	c.Assert(uint(table.w), Equals, w)
+	c.Assert(table.root.t, Equals, t)
 
 	flag := uint64(1)
 	flag <<= (t + w)
 	expectedMask := flag - 1
-	c.Assert(table.mask, Equals, expectedMask)
+	c.Assert(uint64(table.mask), Equals, expectedMask)
 
 	_, rawKeys := s.makePermutedKeys(rng, w) // XXX fields ignored
 	KEY_COUNT := maxDepth                    // some keys ignored
@@ -264,7 +267,7 @@ func (s *XLSuite) doTestEntrySplittingInserts(c *C, rng *xr.PRNG, w uint) {
 		c.Assert(leaf, NotNil)
 		c.Assert(leaf.IsLeaf(), Equals, true)
 
-		err = table.insertLeaf(hc, depth, leaf)
+		err = table.insertLeaf(hc, depth, leaf.Key, leaf.Value, nil)
 		c.Assert(err, IsNil)
 
 		// confirm that the new entry is now present ----------------
@@ -312,7 +315,7 @@ func (s *XLSuite) TestTableInsertsOfRandomishValues(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(dummyRoot.w, Equals, w)
 	c.Assert(dummyRoot.t, Equals, t)
-	c.Assert(dummyRoot.maxTableDepth, Equals, (64-t)/w)
+	c.Assert(dummyRoot.maxTableDepth, Equals, (64-t+w-1)/w)
 
 	table, err := NewTable(depth, dummyRoot)
 	c.Assert(err, IsNil)
@@ -366,7 +369,7 @@ func (s *XLSuite) TestTableInsertsOfRandomishValues(c *C) {
 		c.Assert(leaf, NotNil)
 		c.Assert(leaf.IsLeaf(), Equals, true)
 
-		err = table.insertLeaf(hc, depth, leaf)
+		err = table.insertLeaf(hc, depth, leaf.Key, leaf.Value, nil)
 		c.Assert(err, IsNil)
 
 		// confirm that the new entry is now present ----------------
@@ -381,7 +384,7 @@ func (s *XLSuite) TestTableInsertsOfRandomishValues(c *C) {
 		c.Assert(leaf2, NotNil)
 		c.Assert(leaf2.IsLeaf(), Equals, true)
 
-		err = table.insertLeaf(hc, depth, leaf2)
+		err = table.insertLeaf(hc, depth, leaf2.Key, leaf2.Value, nil)
 		c.Assert(err, IsNil)
 
 		// make sure that a Find returns the new value
@@ -391,7 +394,7 @@ func (s *XLSuite) TestTableInsertsOfRandomishValues(c *C) {
 		c.Assert(*retPtr, Equals, newValue)
 
 		// put the old value back
-		err = table.insertLeaf(hc, depth, leaf)
+		err = table.insertLeaf(hc, depth, leaf.Key, leaf.Value, nil)
 		c.Assert(err, IsNil)
 
 	}
@@ -413,3 +416,91 @@ func (s *XLSuite) TestTableInsertsOfRandomishValues(c *C) {
 		c.Assert(value, IsNil)
 	}
 }
+
+func (s *XLSuite) TestSmallTables(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SMALL_TABLES")
+	}
+	const KEY_COUNT = 2048
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+	h, err := NewHAMT(5, 4)
+	c.Assert(err, IsNil)
+	m, err := NewUint64Map(5, 4)
+	c.Assert(err, IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+		c.Assert(m.Insert(uint64(i), i), IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(m.Validate(), HasLen, 0)
+
+	// tables holding two entries still hold them in the space allocated
+	// with the table, and larger ones have moved them to an entry block,
+	// keys and values together, with room for a power of two
+	var small, grown int
+	var walk func(table *Table)
+	walk = func(table *Table) {
+		entries := len(table.values)
+		if entries == smallTableEntries && len(table.nodes) == 0 {
+			// never more than two entries, as nothing has been deleted
+			if table.root.uint64Keys {
+				st := (*smallUint64Table)(unsafe.Pointer(table))
+				c.Assert(&table.ukeys[0], Equals, &st.ukeyBuf[0])
+				c.Assert(&table.values[0], Equals, &st.valueBuf[0])
+			} else {
+				st := (*smallTable)(unsafe.Pointer(table))
+				c.Assert(&table.keys[0], Equals, &st.keyBuf[0])
+				c.Assert(&table.values[0], Equals, &st.valueBuf[0])
+			}
+			small++
+		} else if entries > smallTableEntries {
+			n := cap(table.values)
+			c.Assert(n >= minEntryBlock && n&(n-1) == 0, Equals, true)
+			if table.root.uint64Keys {
+				c.Assert(cap(table.ukeys), Equals, n)
+				c.Assert(uintptr(unsafe.Pointer(&table.values[0])), Equals,
+					uintptr(unsafe.Pointer(&table.ukeys[0]))+
+						uintptr(n)*unsafe.Sizeof(table.ukeys[0]))
+			} else {
+				c.Assert(cap(table.keys), Equals, n)
+				c.Assert(uintptr(unsafe.Pointer(&table.values[0])), Equals,
+					uintptr(unsafe.Pointer(&table.keys[0]))+
+						uintptr(n)*unsafe.Sizeof(table.keys[0]))
+			}
+			grown++
+		}
+		for i := 0; i < len(table.nodes); i++ {
+			walk(table.nodes[i])
+		}
+	}
+	for _, root := range []*Root{h.root, m.root} {
+		for i, node := root.nextSlot(0); node != nil; i, node = root.nextSlot(i + 1) {
+			if table, ok := node.(*Table); ok {
+				walk(table)
+			}
+		}
+	}
+	c.Assert(small > 0, Equals, true)
+	c.Assert(grown > 0, Equals, true)
+
+	// deleting from and refilling small tables reuses their space
+	perm := xr.MakeSimpleRNG().Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT/2; i++ {
+		c.Assert(h.Delete(bKeys[perm[i]]), IsNil)
+		c.Assert(m.Delete(uint64(perm[i])), IsNil)
+	}
+	for i := 0; i < KEY_COUNT/2; i++ {
+		c.Assert(h.Insert(bKeys[perm[i]], &rawKeys[perm[i]]), IsNil)
+		c.Assert(m.Insert(uint64(perm[i]), perm[i]), IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(m.Validate(), HasLen, 0)
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := h.Find(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, &rawKeys[i])
+		value, err = m.Find(uint64(i))
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+}
@@ -85,7 +85,7 @@ func (s *XLSuite) makePermutedKeys(rng *xr.PRNG, w uint) (
 
 		// lower half of the field
 		key[whichByte] |= byte(fields[i] << whichBit)
-		if whichBit+w >= 8 {
+		if whichBit+w > 8 {
 			key[whichByte+1] |= byte(fields[i] >> (8 - whichBit))
 		}
 		keys[i] = key
@@ -98,7 +98,7 @@ func (s *XLSuite) TestMakingPermutedKeys(c *C) {
 
 	rng := xr.MakeSimpleRNG()
 	var w uint
-	for w = uint(4); w < uint(7); w++ {
+	for w = uint(4); w <= MAX_W; w++ {
 		fields, keys := s.makePermutedKeys(rng, w)
 		flag := uint64(1 << w)
 		mask := flag - 1
@@ -0,0 +1,117 @@
+package hamt_go
+
+// hamt_go/tupleKey.go
+
+import (
+	"encoding/binary"
+)
+
+// A TupleKey is a composite key, an ordered list of other keys.  Two
+// TupleKeys are equal if they have the same number of elements and the
+// elements are pairwise equal.  The hashcode is calculated once, when
+// the key is created, from the hashcodes of the elements.
+type TupleKey struct {
+	elems []KeyI
+	hc    uint64
+}
+
+// Create a TupleKey from one or more keys, none of them nil.  The
+// slice of elements is copied.
+func NewTupleKey(elems ...KeyI) (k TupleKey, err error) {
+	if len(elems) == 0 {
+		err = NilKey
+	} else {
+		hc := fnvOffset64
+		for i := 0; err == nil && i < len(elems); i++ {
+			if elems[i] == nil {
+				err = NilKey
+			} else {
+				hc = mix64(hc*fnvPrime64 ^ elems[i].Hashcode())
+			}
+		}
+		if err == nil {
+			k = TupleKey{elems: append([]KeyI(nil), elems...), hc: hc}
+		}
+	}
+	return
+}
+
+// Return the number of elements in the tuple.
+func (k TupleKey) Len() int {
+	return len(k.elems)
+}
+
+// Return the nth element of the tuple.
+func (k TupleKey) Elem(n int) KeyI {
+	return k.elems[n]
+}
+
+func (k TupleKey) equal(other TupleKey) bool {
+	if k.hc != other.hc || len(k.elems) != len(other.elems) {
+		return false
+	}
+	for i := 0; i < len(k.elems); i++ {
+		if !sameKey(k.elems[i], other.elems[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// KeyI interface ///////////////////////////////////////////////////
+
+func (k TupleKey) Hashcode() uint64 {
+	return k.hc
+}
+
+// Serialization ////////////////////////////////////////////////////
+
+// Encode the tuple as the number of elements, followed by each element
+// as encoded by EncodeKey preceded by its length, the numbers being
+// uvarints.  Every element must be of one of the key types supported
+// by EncodeKey.
+func (k TupleKey) MarshalBinary() (data []byte, err error) {
+	data = binary.AppendUvarint(data, uint64(len(k.elems)))
+	for i := 0; err == nil && i < len(k.elems); i++ {
+		var elem []byte
+		elem, err = EncodeKey(k.elems[i])
+		if err == nil {
+			data = binary.AppendUvarint(data, uint64(len(elem)))
+			data = append(data, elem...)
+		}
+	}
+	if err != nil {
+		data = nil
+	}
+	return
+}
+
+func (k *TupleKey) UnmarshalBinary(data []byte) (err error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return BadKeyEncoding
+	}
+	data = data[n:]
+	elems := make([]KeyI, count)
+	for i := range elems {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return BadKeyEncoding
+		}
+		data = data[n:]
+		elems[i], err = DecodeKey(data[:size])
+		if err != nil {
+			return
+		}
+		data = data[size:]
+	}
+	if len(data) != 0 {
+		return BadKeyEncoding
+	}
+	var t TupleKey
+	t, err = NewTupleKey(elems...)
+	if err == nil {
+		*k = t
+	}
+	return
+}
@@ -0,0 +1,45 @@
+package hamt_go
+
+// hamt_go/uint64Key.go
+
+import (
+	"encoding/binary"
+)
+
+// A Uint64Key is an integer used as a key, such as a 64-bit ID.  A
+// Uint64Map holds such keys unboxed; a HAMT may hold them as KeyIs.
+type Uint64Key uint64
+
+// KeyI interface ///////////////////////////////////////////////////
+
+// Return the key's hashcode.  IDs are often allocated sequentially, so
+// the bits are mixed, using the splitmix64 finalizer, to spread such
+// keys across the trie.  The mixing is a bijection, so no two keys
+// have the same hashcode.
+func (k Uint64Key) Hashcode() uint64 {
+	return mix64(uint64(k))
+}
+
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Serialization ////////////////////////////////////////////////////
+
+// Encode the key as 8 bytes, big-endian.
+func (k Uint64Key) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(k))
+	return b, nil
+}
+
+func (k *Uint64Key) UnmarshalBinary(data []byte) (err error) {
+	if len(data) != 8 {
+		err = BadKeyEncoding
+	} else {
+		*k = Uint64Key(binary.BigEndian.Uint64(data))
+	}
+	return
+}
@@ -0,0 +1,223 @@
+package hamt_go
+
+// hamt_go/uint64Map.go
+
+import (
+	"math/bits"
+)
+
+// A Uint64Map is a HAMT keyed by plain uint64s, such as 64-bit IDs.
+// It uses the same Root and Tables as a HAMT, but its Tables hold keys
+// as uint64s in ukeys rather than as KeyIs, so that keys are compared
+// as integers and neither Find nor an Insert into an existing Table
+// boxes the key or allocates.  Only an entry held directly in a Root
+// slot is kept in a Leaf, its key boxed as a Uint64Key.
+//
+// A Uint64Map is never a Merkle HAMT.
+type Uint64Map struct {
+	root *Root
+}
+
+// Create a new Uint64Map.  The parameters are as for NewHAMT.
+func NewUint64Map(w, t uint) (m Uint64Map, err error) {
+	h, err := NewHAMT(w, t)
+	if err == nil {
+		h.root.uint64Keys = true
+		m = Uint64Map{root: h.root}
+	}
+	return
+}
+
+// Return t which determines the size of the root table (2^t).
+func (m Uint64Map) GetT() uint {
+	return m.root.t
+}
+
+// Return w which determines the size of lower-level tables (2^w).
+func (m Uint64Map) GetW() uint {
+	return m.root.w
+}
+
+// Return the number of entries in the map.
+func (m Uint64Map) GetLeafCount() uint {
+	return m.root.getLeafCount()
+}
+
+// Return the number of tables, including the root table, in the map.
+func (m Uint64Map) GetTableCount() uint {
+	return m.root.getTableCount()
+}
+
+// Walk the map, returning statistics on its structure.
+func (m Uint64Map) Stats() *Stats {
+	return m.root.getStats()
+}
+
+// Check that the trie satisfies the HAMT invariants, returning a list
+// of any violations found.
+func (m Uint64Map) Validate() []Violation {
+	return m.root.validate()
+}
+
+// If there is an entry with the key k in the map, remove it.  If there
+// is no such entry, return NotFound.
+func (m Uint64Map) Delete(k uint64) (err error) {
+	err = m.root.deleteLeaf(Uint64Key(k))
+	if debugValidate {
+		m.root.mustValidate()
+	}
+	return
+}
+
+// If there is an entry with the key k in the map, return the value
+// associated with the key.  If there is no such entry, return nil.
+func (m Uint64Map) Find(k uint64) (interface{}, error) {
+	return m.root.findUint64(k), nil
+}
+
+// Insert the key/value pair into the map, replacing any value already
+// associated with the key.  The value may not be nil.
+func (m Uint64Map) Insert(k uint64, v interface{}) (err error) {
+	if v == nil {
+		err = NilValue
+	} else {
+		err = m.root.insertUint64(k, v)
+	}
+	if debugValidate {
+		m.root.mustValidate()
+	}
+	return
+}
+
+// Return the value associated with k, or nil if there is none.
+func (root *Root) findUint64(k uint64) (value interface{}) {
+	hc := mix64(k)
+	switch node := root.slot(hc & root.mask).(type) {
+	case *Table:
+		if 1 <= root.maxTableDepth {
+			value = node.findUint64(hc>>root.t, 1, k)
+		}
+	case *Leaf:
+		if node.Key.(Uint64Key) == Uint64Key(k) {
+			value = node.Value
+		}
+	}
+	return
+}
+
+// Insert an entry keyed by k, allocating a Leaf only if the entry goes
+// in a Root slot.
+func (root *Root) insertUint64(k uint64, value interface{}) (err error) {
+	hc := mix64(k)
+	slotNbr := hc & root.mask
+
+	switch node := root.slot(slotNbr).(type) {
+	case nil:
+		root.setSlot(slotNbr, &Leaf{Key: Uint64Key(k), Value: value})
+	case *Table:
+		if 1 > root.maxTableDepth {
+			err = MaxTableDepthExceeded
+		} else {
+			err = node.insertUint64(hc>>root.t, 1, k, value)
+		}
+	case *Leaf:
+		if node.Key.(Uint64Key) == Uint64Key(k) {
+			node.Value = value
+		} else if 1 > root.maxTableDepth {
+			err = MaxTableDepthExceeded
+		} else {
+			// replace the leaf with a table holding both entries
+			var tableDeeper *Table
+			tableDeeper, err = NewTableWithLeaf(1, root, node)
+			if err == nil {
+				err = tableDeeper.insertUint64(hc>>root.t, 1, k, value)
+			}
+			if err == nil {
+				root.setSlot(slotNbr, tableDeeper)
+			}
+		}
+	}
+	return
+}
+
+// As Table.findLeaf, but for a uint64 key in a Uint64Map.
+func (table *Table) findUint64(hc uint64, depth uint, k uint64) (
+	value interface{}) {
+
+	maxDepth := table.root.maxTableDepth
+	for {
+		ndx := hc & uint64(table.mask)
+		dataMap, nodeMap, dataBase, nodeBase := table.slotWords(ndx)
+		flag := uint64(1) << (ndx & 63)
+		if dataMap&flag != 0 {
+			slotNbr := dataBase + uint(bits.OnesCount64(dataMap&(flag-1)))
+			if table.ukeys[slotNbr] == k {
+				value = table.values[slotNbr]
+			}
+			return
+		}
+		depth++
+		if nodeMap&flag == 0 || depth > maxDepth {
+			return
+		}
+		hc >>= table.w
+		table = table.nodes[nodeBase+uint(bits.OnesCount64(nodeMap&(flag-1)))]
+	}
+}
+
+// As Table.insertLeaf, but for a uint64 key in a Uint64Map.
+func (table *Table) insertUint64(hc uint64, depth uint, k uint64,
+	value interface{}) (err error) {
+
+	root := table.root
+	for {
+		ndx := hc & uint64(table.mask)
+		nodeNbr, ok := table.nodeSlot(ndx)
+		if ok {
+			depth++
+			if depth > root.maxTableDepth {
+				return MaxTableDepthExceeded
+			}
+			hc >>= table.w
+			table = table.nodes[nodeNbr]
+			continue
+		}
+		dataNbr, ok := table.dataSlot(ndx)
+		if !ok {
+			// the slot is free
+			if len(table.values) == cap(table.values) {
+				table.growEntries()
+			}
+			table.ukeys = insertAt(table.ukeys, dataNbr, k)
+			table.values = insertAt(table.values, dataNbr, value)
+			table.setData(ndx)
+			return
+		}
+		if table.ukeys[dataNbr] == k {
+			table.values[dataNbr] = value
+			return
+		}
+		depth++
+		if depth > root.maxTableDepth {
+			return MaxTableDepthExceeded
+		}
+		// move the existing entry into a new table, then add the new one
+		curKey := table.ukeys[dataNbr]
+		var tableDeeper *Table
+		tableDeeper, err = newTable(depth, root, true)
+		if err == nil {
+			err = tableDeeper.insertUint64(mix64(curKey)>>root.shifts[depth], depth,
+				curKey, table.values[dataNbr])
+		}
+		if err == nil {
+			err = tableDeeper.insertUint64(hc>>table.w, depth, k, value)
+		}
+		if err == nil {
+			table.removeEntry(dataNbr)
+			table.clearData(ndx)
+			table.nodes = insertAt(table.nodes, nodeNbr, tableDeeper)
+			table.setNode(ndx)
+		}
+		return
+	}
+}
@@ -0,0 +1,125 @@
+package hamt_go
+
+// hamt_go/uint64Map_test.go
+
+import (
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"testing"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestUint64MapCtor(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_UINT64_MAP_CTOR")
+	}
+	_, err := NewUint64Map(0, 0)
+	c.Assert(err, Equals, ZeroLengthTables)
+	_, err = NewUint64Map(MAX_W+1, 8)
+	c.Assert(err, Equals, MaxTableSizeExceeded)
+	m, err := NewUint64Map(5, 0)
+	c.Assert(err, IsNil)
+	c.Assert(m.GetT(), Equals, uint(5))
+	c.Assert(m.GetLeafCount(), Equals, uint(0))
+	c.Assert(m.GetTableCount(), Equals, uint(1))
+	c.Assert(m.Insert(42, nil), Equals, NilValue)
+	c.Assert(m.Delete(42), Equals, NotFound)
+}
+
+func (s *XLSuite) TestUint64Map(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_UINT64_MAP")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestUint64Map(c, rng, 4, 4)
+	s.doTestUint64Map(c, rng, 5, 8)
+	s.doTestUint64Map(c, rng, 6, 12)
+	s.doTestUint64Map(c, rng, 8, 8)
+}
+
+func (s *XLSuite) doTestUint64Map(c *C, rng *xr.PRNG, w, t uint) {
+	const KEY_COUNT = 4096
+	// half sequential IDs, half random
+	keys := make([]uint64, KEY_COUNT)
+	for i := 0; i < KEY_COUNT/2; i++ {
+		keys[i] = uint64(i)
+	}
+	seen := make(map[uint64]bool)
+	for i := KEY_COUNT / 2; i < KEY_COUNT; i++ {
+		k := uint64(rng.Int63())<<1 | uint64(KEY_COUNT)
+		for seen[k] {
+			k = uint64(rng.Int63())<<1 | uint64(KEY_COUNT)
+		}
+		seen[k] = true
+		keys[i] = k
+	}
+	m, err := NewUint64Map(w, t)
+	c.Assert(err, IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(m.Insert(keys[i], i), IsNil)
+	}
+	c.Assert(m.GetLeafCount(), Equals, uint(KEY_COUNT))
+	c.Assert(len(m.Validate()), Equals, 0)
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := m.Find(keys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+	// replacing a value changes nothing else
+	c.Assert(m.Insert(keys[0], "new value"), IsNil)
+	value, err := m.Find(keys[0])
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "new value")
+	c.Assert(m.GetLeafCount(), Equals, uint(KEY_COUNT))
+
+	// delete half the keys in random order
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT/2; i++ {
+		c.Assert(m.Delete(keys[perm[i]]), IsNil)
+	}
+	c.Assert(m.Delete(keys[perm[0]]), Equals, NotFound)
+	c.Assert(m.GetLeafCount(), Equals, uint(KEY_COUNT/2))
+	c.Assert(len(m.Validate()), Equals, 0)
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := m.Find(keys[perm[i]])
+		c.Assert(err, IsNil)
+		c.Assert(value == nil, Equals, i < KEY_COUNT/2)
+	}
+	for i := KEY_COUNT / 2; i < KEY_COUNT; i++ {
+		c.Assert(m.Delete(keys[perm[i]]), IsNil)
+	}
+	c.Assert(m.GetLeafCount(), Equals, uint(0))
+	c.Assert(m.GetTableCount(), Equals, uint(1))
+}
+
+// Neither Find nor replacing a value allocates, except that in debug
+// builds every Insert validates the whole trie.
+func (s *XLSuite) TestUint64MapAllocs(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_UINT64_MAP_ALLOCS")
+	}
+	const KEY_COUNT = 1024
+	m, err := NewUint64Map(5, 5)
+	c.Assert(err, IsNil)
+	value := "value"
+	var v interface{} = &value
+	for k := uint64(0); k < KEY_COUNT; k++ {
+		c.Assert(m.Insert(k, v), IsNil)
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		for k := uint64(0); k < KEY_COUNT; k++ {
+			m.Find(k)
+		}
+	})
+	c.Assert(allocs, Equals, float64(0))
+	if !debugValidate {
+		allocs = testing.AllocsPerRun(10, func() {
+			for k := uint64(0); k < KEY_COUNT; k++ {
+				m.Insert(k, v)
+			}
+		})
+		c.Assert(allocs, Equals, float64(0))
+	}
+}
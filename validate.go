@@ -0,0 +1,340 @@
+package hamt_go
+
+// hamt_go/validate.go
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+)
+
+// A Violation describes one way in which a trie fails to satisfy the
+// HAMT invariants.  Path holds the Root slot number followed by the
+// indices of the slots in successive Tables leading to the offending
+// node.
+type Violation struct {
+	Path []uint
+	Msg  string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%v: %s", v.Path, v.Msg)
+}
+
+// Collects violations while walking the trie.
+type validator struct {
+	root       *Root
+	violations []Violation
+}
+
+func (v *validator) add(path []uint, format string, args ...interface{}) {
+	p := make([]uint, len(path))
+	copy(p, path)
+	v.violations = append(v.violations,
+		Violation{Path: p, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Check the entire trie, returning every violation found.  The checks
+// are that
+//   - each Table's bitmap popcounts equal the numbers of its entries
+//     and subtables, and no slot is marked as holding both
+//   - each Table's parameters are those the Root gives for its depth
+//   - the bits of each leaf's hashcode match the path leading to it
+//   - no Table is deeper than Root.maxTableDepth
+//   - no Table is empty or holds nothing but a single leaf
+//   - in a Merkle HAMT, every digest matches what it covers
+//   - in a sparse Root, each group's popcount equals its number of nodes
+//   - with leaf pages, each page holds from 2 to k distinct keys, and
+//     each Table more than k entries
+//   - with collision buckets, each bucket holds two or more distinct
+//     keys, all with the same hashcode
+//   - with compressed paths, each Table's skipped bits are those the
+//     Root gives for the levels skipped, and no Table holds nothing but
+//     a single subtable
+func (root *Root) validate() []Violation {
+	v := &validator{root: root}
+	if root.groups != nil {
+		if !v.checkGroups() {
+			return v.violations
+		}
+	} else if uint(len(root.slots)) != root.slotCount {
+		v.add(nil, "root has %d slots, expected %d",
+			len(root.slots), root.slotCount)
+		return v.violations
+	}
+	for i, node := root.nextSlot(0); node != nil; i, node = root.nextSlot(i + 1) {
+		v.checkNode([]uint{i}, node, uint64(i), root.t, 0)
+	}
+	if root.merkle {
+		for i := uint(0); i < root.slotCount; i++ {
+			var digest []byte
+			if node := root.slots[i]; node == nil {
+				digest = calcEmptyDigest()
+			} else {
+				digest = nodeDigest(node)
+			}
+			if !bytes.Equal(root.tree[root.slotCount+i], digest) {
+				v.add([]uint{i}, "root slot digest is stale")
+			}
+		}
+		for i := root.slotCount - 1; i > 0; i-- {
+			if !bytes.Equal(root.tree[i],
+				calcInnerDigest(root.tree[2*i], root.tree[2*i+1])) {
+				v.add(nil, "root tree digest %d is stale", i)
+			}
+		}
+	}
+	return v.violations
+}
+
+// Check the directory of groups in a sparse Root, returning false if
+// the slots cannot be walked.
+func (v *validator) checkGroups() bool {
+	root := v.root
+	if root.slots != nil || root.merkle ||
+		root.groupShift != rootGroupShift(root.t) {
+		v.add(nil, "sparse root has %d slots, group shift %d, merkle %v",
+			len(root.slots), root.groupShift, root.merkle)
+		return false
+	}
+	return v.checkGroup(root.groups, 0, root.groupShift, true)
+}
+
+// Check a group in the directory of a sparse Root, the first of whose
+// slots is base, and whose slots or groups are indexed by the bits of
+// the slot number from shift up.  Only the top group may be empty.
+func (v *validator) checkGroup(g *rootGroup, base uint64, shift uint,
+	top bool) bool {
+
+	path := []uint{uint(base)}
+	n := bitmapCount([]uint64{g.bitmap})
+	if shift == 0 && (n != len(g.nodes) || g.groups != nil) ||
+		shift > 0 && (n != len(g.groups) || g.nodes != nil) {
+		v.add(path, "root group bitmap popcount %d but group has %d nodes, %d groups",
+			n, len(g.nodes), len(g.groups))
+		return false
+	}
+	ok := true
+	if n == 0 && !top {
+		v.add(path, "empty root group below the top")
+		ok = false
+	}
+	if top && g.bitmap>>(uint64(1)<<(v.root.t-shift)) != 0 {
+		v.add(path, "root group bitmap %016x has bits set beyond 2^t",
+			g.bitmap)
+		ok = false
+	}
+	bitmap := g.bitmap
+	for i := 0; i < n; i++ {
+		chunk := uint64(bits.TrailingZeros64(bitmap))
+		bitmap &= bitmap - 1
+		if shift == 0 {
+			if g.nodes[i] == nil {
+				v.add(path, "nil in root group")
+				ok = false
+			}
+		} else {
+			ok = v.checkGroup(&g.groups[i], base|chunk<<shift,
+				shift-rootGroupBits, false) && ok
+		}
+	}
+	return ok
+}
+
+// Check an entry, held in a Leaf or inline in a Table, whose path
+// leaves the low-order shift bits of its hashcode equal to prefix.
+func (v *validator) checkEntry(path []uint, key KeyI, value interface{},
+	digest []byte, prefix uint64, shift uint) {
+
+	if key == nil || value == nil {
+		v.add(path, "leaf has nil key or value")
+		return
+	}
+	hc := v.root.hash(key)
+	if hc&(uint64(1)<<shift-1) != prefix {
+		v.add(path, "leaf hashcode %016x does not match path", hc)
+	}
+	if v.root.merkle {
+		expected, err := leafDigest(key, value)
+		if err != nil {
+			v.add(path, "leaf cannot be digested: %v", err)
+		} else if !bytes.Equal(expected, digest) {
+			v.add(path, "leaf digest is stale")
+		}
+	}
+}
+
+// Check a leaf page or collision bucket, all of whose entries should
+// have hashcodes whose low-order shift bits equal prefix.
+func (v *validator) checkPage(path []uint, page *Table, prefix uint64,
+	shift uint, bucket bool) {
+
+	root := v.root
+	if bucket && !root.buckets {
+		v.add(path, "collision bucket in a HAMT without them")
+		return
+	} else if (!bucket && root.pageSize < 2) || root.merkle || root.uint64Keys {
+		v.add(path, "page in a HAMT without pages")
+		return
+	}
+	if len(page.nodes) != 0 || len(page.keys) != len(page.values) {
+		v.add(path, "page has %d keys, %d values, %d tables",
+			len(page.keys), len(page.values), len(page.nodes))
+		return
+	}
+	if n := uint(len(page.values)); bucket && n < 2 {
+		v.add(path, "collision bucket holds %d entries", n)
+	} else if !bucket && (n < 2 || n > root.pageSize) {
+		v.add(path, "page holds %d entries, expected 2 to %d", n, root.pageSize)
+	}
+	for i := 0; i < len(page.keys); i++ {
+		childPath := append(path[:len(path):len(path)], uint(i))
+		v.checkEntry(childPath, page.keys[i], page.values[i], nil, prefix, shift)
+		for j := 0; j < i && page.keys[i] != nil; j++ {
+			if page.keys[j] != nil && sameKey(page.keys[i], page.keys[j]) {
+				v.add(childPath, "page holds the same key twice")
+			}
+		}
+	}
+}
+
+// In debug builds, called after every operation which modifies the
+// trie.
+func (root *Root) mustValidate() {
+	violations := root.validate()
+	if len(violations) > 0 {
+		panic(fmt.Sprintf("HAMT invariant violated: %v", violations))
+	}
+}
+
+// Check a node found by following path, and anything below it.  The
+// node is held in a table at depth; prefix holds the low-order shift
+// bits of the hashcode of any leaf which belongs there.
+func (v *validator) checkNode(path []uint, node HTNodeI, prefix uint64,
+	shift uint, depth uint) {
+
+	root := v.root
+	if node.IsLeaf() {
+		leaf := node.(*Leaf)
+		v.checkEntry(path, leaf.Key, leaf.Value, leaf.digest, prefix, shift)
+		return
+	}
+	table := node.(*Table)
+	depth += 1 + uint(table.skip)
+	w := root.widths[len(root.widths)-1]
+	bucket := depth == root.maxTableDepth+1 && table.isPage()
+	if depth <= root.maxTableDepth {
+		w = root.widths[depth]
+	} else if !bucket {
+		v.add(path, "table depth %d exceeds maximum %d",
+			depth, root.maxTableDepth)
+	}
+	if table.skip != 0 {
+		if !root.compressPaths || depth > root.maxTableDepth ||
+			uint(table.skipBits) != root.shifts[depth]-shift ||
+			table.prefix>>table.skipBits != 0 {
+			v.add(path, "compressed path skipping %d levels, %d bits, is malformed",
+				table.skip, table.skipBits)
+			return
+		}
+		// the skipped bits go on the path like those of any other level
+		prefix |= table.prefix << shift
+		shift += uint(table.skipBits)
+	}
+	if table.isPage() {
+		if table.root != root || uint(table.w) != w || table.mask != 0 {
+			v.add(path, "page parameters differ from root's")
+		} else {
+			v.checkPage(path, table, prefix, shift, bucket)
+		}
+		return
+	}
+	if table.root != root || uint(table.w) != w ||
+		uint(table.mask) != 1<<w-1 {
+		v.add(path, "table parameters differ from root's")
+		return
+	}
+	if (table.wide != nil) != (uint(table.w) > maxWordW) {
+		v.add(path, "table with w %d has wrong kind of bitmap", table.w)
+		return
+	}
+	dataMap, nodeMap, words := table.bitmaps()
+	for i := 0; i < words; i++ {
+		if dataMap[i]&nodeMap[i] != 0 {
+			v.add(path, "slots %016x in word %d marked as holding both leaf and table",
+				dataMap[i]&nodeMap[i], i)
+			return
+		}
+	}
+	if table.wide != nil {
+		for i := 1; i < words; i++ {
+			if int(table.wide.data.before[i-1]) != bitmapCount(dataMap[:i]) ||
+				int(table.wide.node.before[i-1]) != bitmapCount(nodeMap[:i]) {
+				v.add(path, "cumulative popcounts for word %d are wrong", i)
+				return
+			}
+		}
+	}
+	keyCount := len(table.keys)
+	if root.uint64Keys {
+		keyCount = len(table.ukeys)
+	}
+	if len(table.values) != keyCount ||
+		root.merkle && len(table.digests) != keyCount {
+		v.add(path, "table has %d keys, %d values, %d digests",
+			keyCount, len(table.values), len(table.digests))
+		return
+	}
+	dataCount, nodeCount := bitmapCount(dataMap[:]), bitmapCount(nodeMap[:])
+	if dataCount != keyCount || nodeCount != len(table.nodes) {
+		v.add(path, "bitmap popcounts %d/%d but table has %d entries, %d tables",
+			dataCount, nodeCount, keyCount, len(table.nodes))
+		return
+	}
+	bitmap := table.bitmap()
+	if uint(table.w) < maxWordW && bitmap[0]>>(uint64(1)<<table.w) != 0 {
+		v.add(path, "bitmap %016x has bits set beyond 2^w", bitmap[0])
+	}
+	if words < maxBitmapWords && bitmapCount(dataMap[words:])+
+		bitmapCount(nodeMap[words:]) != 0 {
+		v.add(path, "bitmap has bits set beyond 2^w")
+	}
+	if len(table.nodes) == 0 {
+		switch keyCount {
+		case 0:
+			v.add(path, "table is empty")
+		case 1:
+			v.add(path, "table holds a single leaf")
+		}
+	}
+	if root.compressPaths && keyCount == 0 && len(table.nodes) == 1 {
+		v.add(path, "table holds nothing but a single table")
+	}
+	if root.pageSize > 1 && table.countUpTo(root.pageSize) <= root.pageSize {
+		v.add(path, "table holds no more entries than a page")
+	}
+	var dataNbr, nodeNbr int
+	for n := uint64(0); n <= uint64(table.mask); n++ {
+		childPath := append(path[:len(path):len(path)], uint(n))
+		if bitmapTest(dataMap[:], n) {
+			var digest []byte
+			if root.merkle {
+				digest = table.digests[dataNbr]
+			}
+			v.checkEntry(childPath, table.keyAt(dataNbr), table.values[dataNbr],
+				digest, prefix|n<<shift, shift+w)
+			dataNbr++
+		} else if bitmapTest(nodeMap[:], n) {
+			child := table.nodes[nodeNbr]
+			nodeNbr++
+			if child == nil {
+				v.add(childPath, "nil in table slot")
+			} else {
+				v.checkNode(childPath, child, prefix|n<<shift, shift+w, depth)
+			}
+		}
+	}
+	if root.merkle && !bytes.Equal(table.digest, table.calcDigest()) {
+		v.add(path, "table digest is stale")
+	}
+}
@@ -0,0 +1,166 @@
+package hamt_go
+
+// hamt_go/validate_test.go
+
+import (
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+var _ = fmt.Print
+
+// Build a small HAMT whose keys share long hashcode prefixes, so that
+// it has a chain of tables below one root slot.
+func (s *XLSuite) makeChainedHAMT(c *C, rng *xr.PRNG, w uint, merkle bool) (
+	h HAMT, bKeys []BytesKey) {
+
+	var err error
+	_, rawKeys := s.makePermutedKeys(rng, w)
+	KEY_COUNT := 64 / w
+	if merkle {
+		h, err = NewMerkleHAMT(w, w)
+	} else {
+		h, err = NewHAMT(w, w)
+	}
+	c.Assert(err, IsNil)
+	bKeys = make([]BytesKey, KEY_COUNT)
+	for i := uint(0); i < KEY_COUNT; i++ {
+		bKeys[i], err = NewBytesKey(rawKeys[i])
+		c.Assert(err, IsNil)
+		err = h.Insert(bKeys[i], rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	return
+}
+
+// Return the first table below the root.
+func firstTable(h HAMT) *Table {
+	for i := uint(0); i < h.root.slotCount; i++ {
+		node := h.root.slots[i]
+		if node != nil && !node.IsLeaf() {
+			return node.(*Table)
+		}
+	}
+	return nil
+}
+
+// Return true if any violation's message contains the text.
+func hasViolation(violations []Violation, text string) bool {
+	for i := 0; i < len(violations); i++ {
+		if strings.Contains(violations[i].Msg, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *XLSuite) TestValidateGoodTries(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_VALIDATE_GOOD_TRIES")
+	}
+	rng := xr.MakeSimpleRNG()
+	const KEY_COUNT = 2048
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	for _, w := range []uint{5, MAX_W} {
+		for _, merkle := range []bool{false, true} {
+			s.doTestValidateGoodTries(c, rng, w, merkle, rawKeys, bKeys)
+		}
+	}
+}
+
+func (s *XLSuite) doTestValidateGoodTries(c *C, rng *xr.PRNG, w uint,
+	merkle bool, rawKeys [][]byte, bKeys []BytesKey) {
+
+	const KEY_COUNT = 2048
+	var h HAMT
+	var err error
+	if merkle {
+		h, err = NewMerkleHAMT(w, 4)
+	} else {
+		h, err = NewHAMT(w, 4)
+	}
+	c.Assert(err, IsNil)
+	c.Assert(h.Validate(), HasLen, 0)
+	for i := 0; i < KEY_COUNT; i++ {
+		err = h.Insert(bKeys[i], rawKeys[i])
+		c.Assert(err, IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT/2; i++ {
+		err = h.Delete(bKeys[perm[i]])
+		c.Assert(err, IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+
+	h2, _ := s.makeChainedHAMT(c, rng, w, merkle)
+	c.Assert(h2.Validate(), HasLen, 0)
+}
+
+func (s *XLSuite) TestValidateBadTries(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_VALIDATE_BAD_TRIES")
+	}
+	rng := xr.MakeSimpleRNG()
+	w := uint(5)
+
+	// bitmap popcount differs from the number of entries
+	h, _ := s.makeChainedHAMT(c, rng, w, false)
+	table := firstTable(h)
+	table.dataMap |= 1 << 31
+	table.nodeMap &^= 1 << 31
+	violations := h.Validate()
+	c.Assert(hasViolation(violations, "popcount"), Equals, true)
+	c.Assert(violations[0].Path, HasLen, 1)
+
+	// a slot marked as holding both a leaf and a table
+	h, _ = s.makeChainedHAMT(c, rng, w, false)
+	table = firstTable(h)
+	table.dataMap |= table.nodeMap
+	c.Assert(hasViolation(h.Validate(), "both leaf and table"), Equals, true)
+
+	// a leaf moved to the wrong slot
+	h, _ = s.makeChainedHAMT(c, rng, w, false)
+	table = firstTable(h)
+	for n := uint64(0); n <= uint64(table.mask); n++ {
+		if table.bitmap()[0]&(1<<n) == 0 {
+			// move the bit for the first entry to an unused index
+			low := table.dataMap & -table.dataMap
+			table.dataMap = table.dataMap&^low | 1<<n
+			break
+		}
+	}
+	c.Assert(hasViolation(h.Validate(), "does not match path"), Equals, true)
+
+	// tables deeper than allowed
+	h, _ = s.makeChainedHAMT(c, rng, w, false)
+	h.root.maxTableDepth = 2
+	c.Assert(hasViolation(h.Validate(), "exceeds maximum"), Equals, true)
+
+	// empty tables and tables holding a single leaf
+	h, _ = s.makeChainedHAMT(c, rng, w, false)
+	table = firstTable(h)
+	for len(table.nodes) > 0 {
+		table = table.nodes[0]
+	}
+	table.keys, table.values = table.keys[:1], table.values[:1]
+	table.dataMap = table.dataMap & -table.dataMap
+	c.Assert(hasViolation(h.Validate(), "single leaf"), Equals, true)
+	table.keys, table.values = table.keys[:0], table.values[:0]
+	table.dataMap = 0
+	c.Assert(hasViolation(h.Validate(), "table is empty"), Equals, true)
+
+	// stale digests in a Merkle HAMT
+	h, _ = s.makeChainedHAMT(c, rng, w, true)
+	c.Assert(h.Validate(), HasLen, 0)
+	table = firstTable(h)
+	for i := 0; i < len(table.values); i++ {
+		table.values[i] = []byte("changed behind our back")
+	}
+	violations = h.Validate()
+	c.Assert(hasViolation(violations, "leaf digest is stale"), Equals, true)
+	c.Assert(violations[0].String(), Matches, `\[[0-9 ]+\]: .*`)
+}
@@ -1,6 +1,6 @@
 package hamt_go
 
 const (
-	VERSION      = "1.1.9"
-	VERSION_DATE = "2017-11-03"
+	VERSION      = "1.2.24"
+	VERSION_DATE = "2026-10-19"
 )
@@ -0,0 +1,101 @@
+package hamt_go
+
+// hamt_go/widths.go
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A width schedule lists w, the log2 of the number of slots, for the
+// tables at each depth below the root: the first width is for tables
+// at depth 1, the second for those at depth 2, and so on, the last
+// width applying to all deeper tables.  A HAMT created by NewHAMT has
+// a schedule with the single width w.
+//
+// Schedules are kept in a canonical form with any trailing repeats of
+// the last width dropped, so that [6, 5, 4, 4] and [6, 5, 4] are the
+// same schedule and equal schedules describe tries of the same shape.
+
+// Check a width schedule, returning it in canonical form.
+func normalizeWidths(widths []uint) (schedule []uint, err error) {
+	if len(widths) == 0 {
+		return nil, ZeroLengthTables
+	}
+	for i := 0; i < len(widths); i++ {
+		if widths[i] == 0 {
+			return nil, ZeroLengthTables
+		} else if widths[i] > MAX_W {
+			return nil, MaxTableSizeExceeded
+		}
+	}
+	n := len(widths)
+	for n > 1 && widths[n-2] == widths[n-1] {
+		n--
+	}
+	schedule = make([]uint, n)
+	copy(schedule, widths)
+	return
+}
+
+// Return the width of tables at the given depth, which is at least 1.
+func widthAt(schedule []uint, depth uint) uint {
+	if depth > uint(len(schedule)) {
+		return schedule[len(schedule)-1]
+	}
+	return schedule[depth-1]
+}
+
+// Return the width of tables at each depth from 0 to the greatest depth
+// at which any hashcode bits remain, taking the width at depth 0 to be
+// t, and the number of hashcode bits used above each depth.  Where
+// fewer bits remain than the schedule gives, the tables at the last
+// depth are narrower, so that all 64 bits are used and keys differing
+// in any of them can be told apart.  For a uniform width w the
+// greatest depth is then (64 - t)/w, rounded up.
+func scheduleDepths(schedule []uint, t uint) (widths, shifts []uint) {
+	widths, shifts = []uint{t}, []uint{0}
+	shift := t
+	for depth := uint(1); shift < 64; depth++ {
+		w := widthAt(schedule, depth)
+		if shift+w > 64 {
+			w = 64 - shift
+		}
+		widths = append(widths, w)
+		shifts = append(shifts, shift)
+		shift += w
+	}
+	return
+}
+
+// Return whether two schedules in canonical form are the same.
+func sameWidths(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the widest width in a schedule.
+func maxWidth(schedule []uint) (w uint) {
+	for i := 0; i < len(schedule); i++ {
+		if schedule[i] > w {
+			w = schedule[i]
+		}
+	}
+	return
+}
+
+// Format a schedule for display, as for example "6,5,4".
+func formatWidths(schedule []uint) string {
+	ws := make([]string, len(schedule))
+	for i := 0; i < len(schedule); i++ {
+		ws[i] = strconv.Itoa(int(schedule[i]))
+	}
+	return strings.Join(ws, ",")
+}
@@ -0,0 +1,227 @@
+package hamt_go
+
+// hamt_go/widths_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+)
+
+var _ = fmt.Print
+
+func (s *XLSuite) TestWidthSchedules(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_WIDTH_SCHEDULES")
+	}
+	schedule, err := normalizeWidths([]uint{6, 5, 4, 4, 4})
+	c.Assert(err, IsNil)
+	c.Assert(schedule, DeepEquals, []uint{6, 5, 4})
+	schedule, err = normalizeWidths([]uint{5, 5})
+	c.Assert(err, IsNil)
+	c.Assert(schedule, DeepEquals, []uint{5})
+
+	_, err = normalizeWidths(nil)
+	c.Assert(err, Equals, ZeroLengthTables)
+	_, err = normalizeWidths([]uint{6, 0, 4})
+	c.Assert(err, Equals, ZeroLengthTables)
+	_, err = normalizeWidths([]uint{6, MAX_W + 1})
+	c.Assert(err, Equals, MaxTableSizeExceeded)
+
+	// a single width uses every bit, the last level taking what is left
+	for w := uint(1); w <= MAX_W; w++ {
+		for _, t := range []uint{0, 4, 13, 60, 64} {
+			widths, shifts := scheduleDepths([]uint{w}, t)
+			depth := uint(len(widths) - 1)
+			c.Assert(depth, Equals, (64-t+w-1)/w)
+			for d := uint(1); d < depth; d++ {
+				c.Assert(widths[d], Equals, w)
+				c.Assert(shifts[d], Equals, t+(d-1)*w)
+			}
+			if depth > 0 {
+				c.Assert(shifts[depth]+widths[depth], Equals, uint(64))
+			}
+		}
+	}
+	// 8 bits in the root, 6+6+5 in the first three tables, then 4s,
+	// the last taking the 3 bits which remain
+	widths, shifts := scheduleDepths([]uint{6, 6, 5, 4}, 8)
+	c.Assert(len(widths), Equals, 1+3+10)
+	c.Assert(widths[:5], DeepEquals, []uint{8, 6, 6, 5, 4})
+	c.Assert(shifts[:5], DeepEquals, []uint{0, 8, 14, 20, 25})
+	c.Assert(widths[13], Equals, uint(3))
+	c.Assert(shifts[13], Equals, uint(61))
+	c.Assert(formatWidths([]uint{6, 6, 5, 4}), Equals, "6,6,5,4")
+
+	_, err = NewHAMTWithWidths([]uint{}, 4)
+	c.Assert(err, Equals, ZeroLengthTables)
+	_, err = NewHAMTWithWidths([]uint{6, 9}, 4)
+	c.Assert(err, Equals, MaxTableSizeExceeded)
+	_, err = NewHAMTWithWidths([]uint{6}, 65)
+	c.Assert(err, Equals, MaxRootTableSizeExceeded)
+	h, err := NewHAMTWithWidths([]uint{6, 4, 4}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(h.GetT(), Equals, uint(6))
+	c.Assert(h.GetW(), Equals, uint(6))
+	c.Assert(h.GetWidths(), DeepEquals, []uint{6, 4})
+	h, err = NewHAMT(5, 5)
+	c.Assert(err, IsNil)
+	c.Assert(h.GetWidths(), DeepEquals, []uint{5})
+}
+
+func (s *XLSuite) TestHAMTWithWidths(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HAMT_WITH_WIDTHS")
+	}
+	rng := xr.MakeSimpleRNG()
+	s.doTestHAMTWithWidths(c, rng, []uint{6, 5, 4}, 4)
+	s.doTestHAMTWithWidths(c, rng, []uint{8, 6, 3}, 2)
+	s.doTestHAMTWithWidths(c, rng, []uint{3, 7}, 0)
+}
+
+func (s *XLSuite) doTestHAMTWithWidths(c *C, rng *xr.PRNG,
+	widths []uint, t uint) {
+
+	const KEY_COUNT = 4096
+	rawKeys, bKeys := makeSomeUniqueKeys(KEY_COUNT, 16)
+
+	h, err := NewHAMTWithWidths(widths, t)
+	c.Assert(err, IsNil)
+	m, err := NewMerkleHAMTWithWidths(widths, t)
+	c.Assert(err, IsNil)
+	for i := 0; i < KEY_COUNT; i++ {
+		c.Assert(h.Insert(bKeys[i], &rawKeys[i]), IsNil)
+		c.Assert(m.Insert(bKeys[i], rawKeys[i]), IsNil)
+	}
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT))
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(m.Validate(), HasLen, 0)
+	for i := 0; i < KEY_COUNT; i++ {
+		value, err := h.Find(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, &rawKeys[i])
+	}
+
+	// tables at each depth have the width the schedule gives
+	st := h.Stats()
+	c.Assert(st.Widths, DeepEquals, h.GetWidths())
+	for i := uint(0); i < h.root.slotCount; i++ {
+		if table, ok := h.root.slots[i].(*Table); ok {
+			c.Assert(uint(table.w), Equals, widthAt(h.GetWidths(), 1))
+			for j := 0; j < len(table.nodes); j++ {
+				c.Assert(uint(table.nodes[j].w), Equals, widthAt(h.GetWidths(), 2))
+			}
+		}
+	}
+
+	// the frozen copy finds the same values
+	if maxWidth(h.GetWidths()) <= maxWordW {
+		f, err := h.Freeze()
+		c.Assert(err, IsNil)
+		for i := 0; i < KEY_COUNT; i++ {
+			value, err := f.Find(bKeys[i])
+			c.Assert(err, IsNil)
+			c.Assert(value, Equals, &rawKeys[i])
+		}
+	}
+
+	// proofs verify against the schedule, and only against it
+	digest := m.RootDigest()
+	for i := 0; i < 64; i++ {
+		proof, err := m.Prove(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(proof.Widths, DeepEquals, m.GetWidths())
+		c.Assert(Verify(digest, bKeys[i], rawKeys[i], proof), IsNil)
+		proof.Widths = append(proof.Widths, 2)
+		c.Assert(Verify(digest, bKeys[i], rawKeys[i], proof), Equals, InvalidProof)
+		proof.Widths = nil
+		c.Assert(Verify(digest, bKeys[i], rawKeys[i], proof), Equals, InvalidProof)
+	}
+
+	// a replica with the same schedule syncs; one without it does not
+	m2, err := NewMerkleHAMTWithWidths(widths, t)
+	c.Assert(err, IsNil)
+	changes, _, err := s.doSync(c, m, m2)
+	c.Assert(err, IsNil)
+	c.Assert(changes, Equals, uint(KEY_COUNT))
+	c.Assert(bytes.Equal(m.RootDigest(), m2.RootDigest()), Equals, true)
+	m3, err := NewMerkleHAMT(m.GetW(), m.GetT())
+	c.Assert(err, IsNil)
+	_, _, err = s.doSync(c, m, m3)
+	c.Assert(err, Equals, MismatchedReplicas)
+
+	// deleting half the keys leaves a valid trie
+	perm := rng.Perm(KEY_COUNT)
+	for i := 0; i < KEY_COUNT/2; i++ {
+		c.Assert(h.Delete(bKeys[perm[i]]), IsNil)
+		c.Assert(m.Delete(bKeys[perm[i]]), IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(m.Validate(), HasLen, 0)
+	c.Assert(h.GetLeafCount(), Equals, uint(KEY_COUNT/2))
+}
+
+func (s *XLSuite) TestAllHashBitsUsed(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ALL_HASH_BITS_USED")
+	}
+	// in each of these (64 - t) is not a multiple of w
+	s.doTestAllHashBitsUsed(c, 6, 6)
+	s.doTestAllHashBitsUsed(c, 5, 6)
+	s.doTestAllHashBitsUsed(c, 7, 3)
+}
+
+func (s *XLSuite) doTestAllHashBitsUsed(c *C, w, t uint) {
+	// BytesKeys whose hashcodes differ only in their top bits, the last
+	// to be used
+	var bKeys []BytesKey
+	for _, top := range []byte{0x00, 0x80, 0x40, 0xc0} {
+		raw := make([]byte, 8)
+		raw[7] = top
+		bKey, err := NewBytesKey(raw)
+		c.Assert(err, IsNil)
+		bKeys = append(bKeys, bKey)
+	}
+	h, err := NewHAMT(w, t)
+	c.Assert(err, IsNil)
+	for i := 0; i < len(bKeys); i++ {
+		c.Assert(h.Insert(bKeys[i], i), IsNil)
+	}
+	c.Assert(h.Validate(), HasLen, 0)
+	c.Assert(h.Stats().MaxDepth, Equals, h.root.maxTableDepth)
+	for i := 0; i < len(bKeys); i++ {
+		value, err := h.Find(bKeys[i])
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, i)
+	}
+	if w <= maxWordW {
+		a, err := NewArenaHAMT(w, t)
+		c.Assert(err, IsNil)
+		for i := 0; i < len(bKeys); i++ {
+			c.Assert(a.Insert(bKeys[i], i), IsNil)
+		}
+		f, err := h.Freeze()
+		c.Assert(err, IsNil)
+		for i := 0; i < len(bKeys); i++ {
+			value, err := a.Find(bKeys[i])
+			c.Assert(err, IsNil)
+			c.Assert(value, Equals, i)
+			value, err = f.Find(bKeys[i])
+			c.Assert(err, IsNil)
+			c.Assert(value, Equals, i)
+		}
+	}
+
+	// keys whose hashcodes are the same in all 64 bits still collide
+	raw := make([]byte, 9)
+	raw[8] = 1
+	other, err := NewBytesKey(raw)
+	c.Assert(err, IsNil)
+	c.Assert(h.Insert(other, "x"), Equals, MaxTableDepthExceeded)
+
+	for i := 0; i < len(bKeys); i++ {
+		c.Assert(h.Delete(bKeys[i]), IsNil)
+	}
+	c.Assert(h.GetTableCount(), Equals, uint(1))
+}